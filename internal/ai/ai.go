@@ -4,13 +4,20 @@
 package ai
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 )
 
 // AskFunc is a function that sends a question to the AI.
-type AskFunc func(history []string) (string, error)
+// The history is a sequence of user and assistant messages,
+// ending with the question. Cancelling the context cancels the request.
+type AskFunc func(ctx context.Context, history []Message) (string, error)
+
+// EmbedFunc is a function that converts the text
+// to an embedding vector.
+type EmbedFunc func(text string) ([]float32, error)
 
 // Ask sends a question to the AI and returns the answer.
 // It uses the configuration prompt and conversation history
@@ -18,14 +25,48 @@ type AskFunc func(history []string) (string, error)
 // Ask is the main interface of the ai package.
 var Ask AskFunc
 
+// Embed returns the embedding vector for the text.
+// It is nil if the embedding model is not configured.
+var Embed EmbedFunc
+
 // Conf describes the AI configuration.
 var Conf Config
 
 // HTTP client used to make requests to the AI.
 var httpClient *http.Client
 
-// message represents a single message in the conversation.
-type message struct {
+// temperatureKey is the context key for the temperature.
+type temperatureKey struct{}
+
+// WithTemperature returns a context that makes the AI answer
+// with the given temperature instead of the configured one.
+func WithTemperature(ctx context.Context, temp float64) context.Context {
+	return context.WithValue(ctx, temperatureKey{}, temp)
+}
+
+// TemperatureFrom returns the temperature set with WithTemperature, if any.
+func TemperatureFrom(ctx context.Context) (float64, bool) {
+	temp, ok := ctx.Value(temperatureKey{}).(float64)
+	return temp, ok
+}
+
+// promptKey is the context key for the system prompt.
+type promptKey struct{}
+
+// WithPrompt returns a context that makes the AI use the given
+// system prompt instead of the configured one.
+func WithPrompt(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, promptKey{}, prompt)
+}
+
+// PromptFrom returns the system prompt set with WithPrompt, if any.
+func PromptFrom(ctx context.Context) (string, bool) {
+	prompt, ok := ctx.Value(promptKey{}).(string)
+	return prompt, ok
+}
+
+// Message represents a single message in the conversation.
+type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
@@ -40,15 +81,14 @@ func init() {
 	Conf = config
 
 	// Set the Ask function based on the vendor.
-	switch config.Vendor {
-	case "openai":
-		Ask = openai{config}.Ask
-	case "ollama":
-		Ask = ollama{config}.Ask
-	default:
-		fmt.Println("Unknown AI vendor:", config.Vendor)
+	Ask, err = New(config)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	if config.EmbedModel != "" {
+		Embed = newEmbed(config)
+	}
 
 	// Create an HTTP client with a timeout.
 	httpClient = &http.Client{
@@ -56,16 +96,37 @@ func init() {
 	}
 }
 
+// New returns the function that sends questions to the AI
+// with the given configuration.
+func New(config Config) (AskFunc, error) {
+	switch config.Vendor {
+	case "openai":
+		return openai{config}.Ask, nil
+	case "ollama":
+		return ollama{config}.Ask, nil
+	default:
+		return nil, fmt.Errorf("unknown AI vendor: %s", config.Vendor)
+	}
+}
+
+// newEmbed returns the function that converts texts
+// to embeddings with the given configuration.
+func newEmbed(config Config) EmbedFunc {
+	switch config.Vendor {
+	case "openai":
+		return openai{config}.Embed
+	case "ollama":
+		return ollama{config}.Embed
+	default:
+		return nil
+	}
+}
+
 // buildMessages constructs a list of messages from the prompt
 // and the conversation history (a sequence of user and assistant messages).
-func buildMessages(prompt string, history []string) []message {
-	var messages []message
-	messages = append(messages, message{Role: "system", Content: prompt})
-	for i := 0; i < len(history); i += 2 {
-		messages = append(messages, message{Role: "user", Content: history[i]})
-		if i+1 < len(history) {
-			messages = append(messages, message{Role: "assistant", Content: history[i+1]})
-		}
-	}
+func buildMessages(prompt string, history []Message) []Message {
+	messages := make([]Message, 0, len(history)+1)
+	messages = append(messages, Message{Role: "system", Content: prompt})
+	messages = append(messages, history...)
 	return messages
 }
@@ -11,37 +11,45 @@ func Test_buildMessages(t *testing.T) {
 
 	tests := []struct {
 		name    string
-		history []string
-		want    []message
+		history []Message
+		want    []Message
 	}{
 		{
 			name:    "No history",
-			history: []string{},
-			want: []message{
+			history: []Message{},
+			want: []Message{
 				{Role: "system", Content: prompt},
 			},
 		},
 		{
 			name:    "Single user message",
-			history: []string{"Hello"},
-			want: []message{
+			history: []Message{{Role: "user", Content: "Hello"}},
+			want: []Message{
 				{Role: "system", Content: prompt},
 				{Role: "user", Content: "Hello"},
 			},
 		},
 		{
-			name:    "User and assistant message",
-			history: []string{"Hello", "Hi there!"},
-			want: []message{
+			name: "User and assistant message",
+			history: []Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "assistant", Content: "Hi there!"},
+			},
+			want: []Message{
 				{Role: "system", Content: prompt},
 				{Role: "user", Content: "Hello"},
 				{Role: "assistant", Content: "Hi there!"},
 			},
 		},
 		{
-			name:    "Multiple user and assistant messages",
-			history: []string{"Hello", "Hi there!", "How are you?", "I'm fine, thank you."},
-			want: []message{
+			name: "Multiple user and assistant messages",
+			history: []Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "assistant", Content: "Hi there!"},
+				{Role: "user", Content: "How are you?"},
+				{Role: "assistant", Content: "I'm fine, thank you."},
+			},
+			want: []Message{
 				{Role: "system", Content: prompt},
 				{Role: "user", Content: "Hello"},
 				{Role: "assistant", Content: "Hi there!"},
@@ -50,12 +58,14 @@ func Test_buildMessages(t *testing.T) {
 			},
 		},
 		{
-			name:    "Odd number of messages",
-			history: []string{"Hello", "Hi there!", "How are you?"},
-			want: []message{
+			name: "Consecutive user messages",
+			history: []Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "user", Content: "How are you?"},
+			},
+			want: []Message{
 				{Role: "system", Content: prompt},
 				{Role: "user", Content: "Hello"},
-				{Role: "assistant", Content: "Hi there!"},
 				{Role: "user", Content: "How are you?"},
 			},
 		},
@@ -68,3 +78,13 @@ func Test_buildMessages(t *testing.T) {
 		})
 	}
 }
+
+func TestNew(t *testing.T) {
+	for _, vendor := range []string{"openai", "ollama"} {
+		ask, err := New(Config{Vendor: vendor})
+		be.Err(t, err, nil)
+		be.True(t, ask != nil)
+	}
+	_, err := New(Config{Vendor: "unknown"})
+	be.Err(t, err, "unknown AI vendor: unknown")
+}
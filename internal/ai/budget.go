@@ -0,0 +1,124 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Prompt to summarize the older part of the conversation.
+const summaryPrompt = `Summarize the conversation between the user and the command-line assistant in a few sentences. Keep the details needed to answer follow-up questions: the user's goals, file and directory names, the suggested commands and their important options. Reply with the summary only.`
+
+// Estimated number of tokens per message in addition to the content.
+const messageOverhead = 4
+
+// chatFunc sends the messages (including the system prompt)
+// to the AI and returns the answer.
+type chatFunc func(ctx context.Context, messages []Message) (string, error)
+
+// estimateTokens returns a rough (and rather pessimistic)
+// estimate of the number of tokens in the text.
+func estimateTokens(s string) int {
+	return (len(s)+2)/3 + messageOverhead
+}
+
+// countTokens returns the estimated number of tokens in the messages.
+func countTokens(messages []Message) int {
+	var n int
+	for _, msg := range messages {
+		n += estimateTokens(msg.Content)
+	}
+	return n
+}
+
+// MaxExtraSize returns the maximum size (in bytes) of the text that can be
+// added to the message so that the message and the system prompt still fit
+// into the token budget, or -1 if there is no budget.
+func MaxExtraSize(config Config, message string) int {
+	if config.MaxTokens <= 0 {
+		return -1
+	}
+	tokens := config.MaxTokens - estimateTokens(config.Prompt) - estimateTokens(message)
+	return max(3*(tokens-1), 0)
+}
+
+// fitHistory makes the conversation history (along with the system prompt)
+// fit into the token budget. Drops the oldest messages, or replaces them
+// with a summary, depending on the configured strategy. Never drops
+// the last message (the question), and fails if it does not fit alone.
+func fitHistory(ctx context.Context, config Config, summarize chatFunc, history []Message) ([]Message, error) {
+	budget := config.MaxTokens - estimateTokens(config.Prompt)
+	total := countTokens(history)
+	if config.MaxTokens <= 0 || total <= budget {
+		return history, nil
+	}
+	if len(history) == 0 {
+		return history, nil
+	}
+	if question := countTokens(history[len(history)-1:]); question > budget {
+		return nil, fmt.Errorf("the question is too long: about %d tokens, the limit is %d (HOWTO_AI_MAX_TOKENS)",
+			question, config.MaxTokens)
+	}
+
+	kept := keepRecent(history, budget)
+	dropped := history[:len(history)-len(kept)]
+	if config.ContextStrategy != "summarize" || summarize == nil {
+		return kept, nil
+	}
+
+	summary, err := summarizeHistory(ctx, summarize, dropped)
+	if err != nil {
+		// The summary is nice to have, but not essential.
+		return kept, nil
+	}
+	summaryMsg := Message{Role: "system", Content: "Summary of the earlier conversation:\n" + summary}
+	kept = keepRecent(kept, budget-countTokens([]Message{summaryMsg}))
+	if len(kept) == 0 {
+		return keepRecent(history, budget), nil
+	}
+	return append([]Message{summaryMsg}, kept...), nil
+}
+
+// keepRecent returns the most recent messages that fit into the budget.
+// The result always starts with a user message, so that no answer
+// is left without its question.
+func keepRecent(history []Message, budget int) []Message {
+	start := len(history)
+	var total int
+	for start > 0 {
+		n := estimateTokens(history[start-1].Content)
+		if total+n > budget {
+			break
+		}
+		total += n
+		start--
+	}
+	for start < len(history)-1 && history[start].Role != "user" {
+		start++
+	}
+	if start == len(history) {
+		return nil
+	}
+	return history[start:]
+}
+
+// summarizeHistory asks the AI to summarize the messages.
+func summarizeHistory(ctx context.Context, summarize chatFunc, history []Message) (string, error) {
+	var b strings.Builder
+	for _, msg := range history {
+		role := "User"
+		if msg.Role == "assistant" {
+			role = "Assistant"
+		}
+		_, _ = fmt.Fprintf(&b, "%s: %s\n\n", role, msg.Content)
+	}
+	messages := []Message{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: b.String()},
+	}
+	summary, err := summarize(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
@@ -0,0 +1,112 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_estimateTokens(t *testing.T) {
+	be.Equal(t, estimateTokens(""), messageOverhead)
+	be.Equal(t, estimateTokens("hello"), 2+messageOverhead)
+	be.Equal(t, estimateTokens(strings.Repeat("a", 300)), 100+messageOverhead)
+}
+
+func TestMaxExtraSize(t *testing.T) {
+	config := Config{Prompt: "prompt", MaxTokens: 100}
+	size := MaxExtraSize(config, "question")
+	be.Equal(t, size, 258)
+	message := "question" + strings.Repeat("a", size)
+	be.True(t, estimateTokens(config.Prompt)+estimateTokens(message) <= config.MaxTokens)
+
+	be.Equal(t, MaxExtraSize(config, strings.Repeat("a", 300)), 0)
+	be.Equal(t, MaxExtraSize(Config{}, "question"), -1)
+}
+
+func Test_fitHistory(t *testing.T) {
+	// Each message is about 100 tokens.
+	msg := func(role string, c string) Message {
+		return Message{Role: role, Content: strings.Repeat(c, 288)}
+	}
+	history := []Message{
+		msg("user", "a"), msg("assistant", "b"),
+		msg("user", "c"), msg("assistant", "d"),
+		msg("user", "e"),
+	}
+	config := Config{Prompt: "", MaxTokens: 1000, ContextStrategy: "drop"}
+	noSummary := func(_ context.Context, messages []Message) (string, error) {
+		t.Fatal("unexpected summary")
+		return "", nil
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		got, err := fitHistory(context.Background(), config, noSummary, history)
+		be.Err(t, err, nil)
+		be.Equal(t, got, history)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		config := config
+		config.MaxTokens = 350
+		got, err := fitHistory(context.Background(), config, noSummary, history)
+		be.Err(t, err, nil)
+		be.Equal(t, got, history[2:])
+	})
+
+	t.Run("starts with question", func(t *testing.T) {
+		config := config
+		config.MaxTokens = 250
+		got, err := fitHistory(context.Background(), config, noSummary, history)
+		be.Err(t, err, nil)
+		be.Equal(t, got, history[4:])
+	})
+
+	t.Run("summarize", func(t *testing.T) {
+		config := config
+		config.MaxTokens = 350
+		config.ContextStrategy = "summarize"
+		var transcript string
+		summarize := func(_ context.Context, messages []Message) (string, error) {
+			be.Equal(t, messages[0].Content, summaryPrompt)
+			transcript = messages[1].Content
+			return "The user asked about a and b.", nil
+		}
+		got, err := fitHistory(context.Background(), config, summarize, history)
+		be.Err(t, err, nil)
+		be.True(t, strings.HasPrefix(transcript, "User: aaa"))
+		be.True(t, strings.Contains(transcript, "\n\nAssistant: bbb"))
+		be.Equal(t, len(got), 4)
+		be.Equal(t, got[0], Message{Role: "system", Content: "Summary of the earlier conversation:\nThe user asked about a and b."})
+		be.Equal(t, got[1:], history[2:])
+	})
+
+	t.Run("summary fails", func(t *testing.T) {
+		config := config
+		config.MaxTokens = 350
+		config.ContextStrategy = "summarize"
+		summarize := func(_ context.Context, messages []Message) (string, error) {
+			return "", errors.New("failed")
+		}
+		got, err := fitHistory(context.Background(), config, summarize, history)
+		be.Err(t, err, nil)
+		be.Equal(t, got, history[2:])
+	})
+
+	t.Run("question too long", func(t *testing.T) {
+		config := config
+		config.MaxTokens = 50
+		_, err := fitHistory(context.Background(), config, noSummary, history)
+		be.Err(t, err, "the question is too long: about 100 tokens, the limit is 50 (HOWTO_AI_MAX_TOKENS)")
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		config := config
+		config.MaxTokens = 0
+		got, err := fitHistory(context.Background(), config, noSummary, history)
+		be.Err(t, err, nil)
+		be.Equal(t, got, history)
+	})
+}
@@ -0,0 +1,180 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Cache stores the answers on disk, so that identical questions
+// are answered instantly without calling the AI.
+type Cache struct {
+	dir    string
+	config Config
+	now    func() time.Time
+}
+
+// cacheEntry is a cached answer.
+type cacheEntry struct {
+	Time   time.Time `json:"time"`
+	Answer string    `json:"answer"`
+}
+
+// NewCache creates a cache in the given directory.
+// Uses the configuration to build the cache keys
+// and to limit the cache lifetime and size.
+func NewCache(dir string, config Config) *Cache {
+	return &Cache{dir: dir, config: config, now: time.Now}
+}
+
+// Wrap returns an ask function that looks up the answer
+// in the cache first, and only calls the AI on a cache miss.
+// Caching is best effort: cache errors never fail the question.
+func (c *Cache) Wrap(ask AskFunc) AskFunc {
+	if c.config.CacheTTL <= 0 {
+		return ask
+	}
+	return func(ctx context.Context, history []Message) (string, error) {
+		key := c.key(ctx, history)
+		if answer, ok := c.get(key); ok {
+			return answer, nil
+		}
+		answer, err := ask(ctx, history)
+		if err != nil {
+			return "", err
+		}
+		c.set(key, answer)
+		return answer, nil
+	}
+}
+
+// Clear removes all cached answers.
+func (c *Cache) Clear() error {
+	err := os.RemoveAll(c.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// key returns the cache key for the conversation. The key depends on
+// the vendor, model, prompt and temperature (the ones set in the context,
+// if any), and on the messages with normalized whitespace.
+func (c *Cache) key(ctx context.Context, history []Message) string {
+	messages := make([]Message, len(history))
+	for i, msg := range history {
+		content := strings.Join(strings.Fields(msg.Content), " ")
+		messages[i] = Message{Role: msg.Role, Content: content}
+	}
+	prompt := c.config.Prompt
+	if p, ok := PromptFrom(ctx); ok {
+		prompt = p
+	}
+	temperature := c.config.Temperature
+	if temp, ok := TemperatureFrom(ctx); ok {
+		temperature = temp
+	}
+	data, _ := json.Marshal(struct {
+		Vendor      string
+		Model       string
+		Prompt      string
+		Temperature float64
+		Messages    []Message
+	}{c.config.Vendor, c.config.Model, prompt, temperature, messages})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached answer for the key,
+// unless it's missing or expired.
+func (c *Cache) get(key string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(c.dir, key+".json"))
+	if err != nil {
+		return "", false
+	}
+	var entry cacheEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil || c.now().Sub(entry.Time) > c.config.CacheTTL {
+		return "", false
+	}
+	return entry.Answer, true
+}
+
+// set caches the answer for the key and removes
+// the oldest entries if the cache is too large.
+func (c *Cache) set(key, answer string) {
+	data, err := json.Marshal(cacheEntry{Time: c.now(), Answer: answer})
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return
+	}
+	// Write to a temporary file first, so that concurrent readers
+	// never see a partially written entry.
+	tmp, err := os.CreateTemp(c.dir, "."+key+".*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, key+".json"))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	c.prune()
+}
+
+// prune removes the expired entries, and then the oldest ones
+// until the cache fits into the size limit.
+func (c *Cache) prune() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	now := c.now()
+	for _, entry := range dirEntries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		if now.Sub(info.ModTime()) > c.config.CacheTTL {
+			_ = os.Remove(path)
+			continue
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	slices.SortFunc(files, func(a, b file) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, f := range files {
+		if total <= c.config.CacheSize {
+			break
+		}
+		_ = os.Remove(f.path)
+		total -= f.size
+	}
+}
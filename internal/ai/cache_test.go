@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+)
+
+func TestCache(t *testing.T) {
+	config := Config{
+		Vendor:    "openai",
+		Model:     "gpt-4o",
+		Prompt:    "prompt",
+		CacheTTL:  time.Hour,
+		CacheSize: 1024 * 1024,
+	}
+	counter := func(calls *int) AskFunc {
+		return func(_ context.Context, history []Message) (string, error) {
+			*calls++
+			return "answer to " + history[len(history)-1].Content, nil
+		}
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		var calls int
+		ask := NewCache(t.TempDir(), config).Wrap(counter(&calls))
+		answer, err := ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Err(t, err, nil)
+		be.Equal(t, answer, "answer to list files")
+		answer, err = ask(context.Background(), []Message{{Role: "user", Content: "  list\tfiles "}})
+		be.Err(t, err, nil)
+		be.Equal(t, answer, "answer to list files")
+		be.Equal(t, calls, 1)
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		var calls int
+		dir := t.TempDir()
+		ask := NewCache(dir, config).Wrap(counter(&calls))
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list all files"}})
+		be.Equal(t, calls, 2)
+
+		// Different model.
+		other := config
+		other.Model = "gpt-4o-mini"
+		ask = NewCache(dir, other).Wrap(counter(&calls))
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Equal(t, calls, 3)
+
+		// Different temperature.
+		ctx := WithTemperature(context.Background(), 1.5)
+		_, _ = ask(ctx, []Message{{Role: "user", Content: "list files"}})
+		be.Equal(t, calls, 4)
+
+		// Different prompt.
+		ctx = WithPrompt(context.Background(), "other prompt")
+		_, _ = ask(ctx, []Message{{Role: "user", Content: "list files"}})
+		be.Equal(t, calls, 5)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var calls int
+		cache := NewCache(t.TempDir(), config)
+		ask := cache.Wrap(counter(&calls))
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		cache.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Equal(t, calls, 2)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var calls int
+		ask := NewCache(t.TempDir(), config).Wrap(func(_ context.Context, history []Message) (string, error) {
+			calls++
+			return "", errors.New("failed")
+		})
+		_, err := ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Err(t, err, "failed")
+		_, err = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Err(t, err, "failed")
+		be.Equal(t, calls, 2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var calls int
+		disabled := config
+		disabled.CacheTTL = 0
+		ask := NewCache(t.TempDir(), disabled).Wrap(counter(&calls))
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Equal(t, calls, 2)
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		var calls int
+		dir := t.TempDir()
+		small := config
+		small.CacheSize = 200
+		ask := NewCache(dir, small).Wrap(counter(&calls))
+		for _, q := range []string{"one", "two", "three", "four"} {
+			_, _ = ask(context.Background(), []Message{{Role: "user", Content: q}})
+		}
+		entries, err := os.ReadDir(dir)
+		be.Err(t, err, nil)
+		be.True(t, len(entries) < 4)
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		var calls int
+		cache := NewCache(t.TempDir(), config)
+		ask := cache.Wrap(counter(&calls))
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		err := cache.Clear()
+		be.Err(t, err, nil)
+		_, _ = ask(context.Background(), []Message{{Role: "user", Content: "list files"}})
+		be.Equal(t, calls, 2)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,11 +15,16 @@ const ollamaURL = "http://localhost:11434/api/chat"
 const defaultModel = "gpt-4o"
 const defaultTemperature = 0
 const defaultTimeout = 30 * time.Second
+const defaultOpenAIMaxTokens = 32000
+const defaultOllamaMaxTokens = 4000
+const defaultContextStrategy = "drop"
+const defaultCacheTTL = 7 * 24 * time.Hour
+const defaultCacheSize = 10 * 1024 * 1024
 const defaultPrompt = `You are a command-line assistant. You help the user solve tasks using command-line tools for the given platform (%s).
 
-In your answer, the first line MUST be the suggested command. Do NOT use Markdown or any other formatting. Print the command in plain text WITHOUT any surrounding text.
+In your answer, the first line MUST be the suggested command. Print the command in plain text WITHOUT any surrounding text or formatting.
 
-The second line must be blank. The third line must contain a brief explanation of the command.
+The second line must be blank. The third line must contain a brief explanation of the command. You may use Markdown in the explanation (inline code, emphasis, lists), but do NOT use headings or code blocks.
 
 If you suggest multiple commands connected with pipes, you MUST provide separate explanations for each command. Print each explanation on a separate line.`
 
@@ -31,6 +37,19 @@ type Config struct {
 	Prompt      string
 	Temperature float64
 	Timeout     time.Duration
+	// Maximum number of tokens to send to the AI (estimated),
+	// and what to do with the older messages if they don't fit:
+	// drop them or summarize them with the summary model.
+	MaxTokens       int
+	ContextStrategy string
+	SummaryModel    string
+	// Embedding model and its API endpoint (empty model disables embeddings).
+	EmbedURL   string
+	EmbedModel string
+	// How long to keep cached answers (0 disables the cache).
+	CacheTTL time.Duration
+	// Maximum total size of cached answers in bytes.
+	CacheSize int64
 }
 
 // loadConfig reads the AI configuration from environment variables.
@@ -78,13 +97,90 @@ func loadConfig() (Config, error) {
 		timeout = defaultTimeout
 	}
 
+	maxTokens, err := strconv.Atoi(os.Getenv("HOWTO_AI_MAX_TOKENS"))
+	if err != nil || maxTokens <= 0 {
+		maxTokens = defaultOpenAIMaxTokens
+		if vendor == "ollama" {
+			maxTokens = defaultOllamaMaxTokens
+		}
+	}
+
+	strategy := os.Getenv("HOWTO_AI_CONTEXT")
+	if strategy == "" {
+		strategy = defaultContextStrategy
+	}
+	if strategy != "drop" && strategy != "summarize" {
+		err := fmt.Errorf("unknown context strategy: %s", strategy)
+		return Config{}, err
+	}
+
+	summaryModel := os.Getenv("HOWTO_AI_SUMMARY_MODEL")
+	if summaryModel == "" {
+		summaryModel = model
+	}
+
+	embedModel := os.Getenv("HOWTO_AI_EMBED_MODEL")
+	embedURL := os.Getenv("HOWTO_AI_EMBED_URL")
+	if embedURL == "" {
+		embedURL = defaultEmbedURL(vendor, url)
+	}
+	if embedModel != "" && embedURL == "" {
+		err := fmt.Errorf("set HOWTO_AI_EMBED_URL to use the embedding model")
+		return Config{}, err
+	}
+
+	cacheTTL := defaultCacheTTL
+	cacheHours, err := strconv.Atoi(os.Getenv("HOWTO_CACHE_TTL"))
+	if err == nil && cacheHours >= 0 {
+		cacheTTL = time.Duration(cacheHours) * time.Hour
+	}
+
+	var cacheSize int64 = defaultCacheSize
+	cacheMB, err := strconv.Atoi(os.Getenv("HOWTO_CACHE_SIZE"))
+	if err == nil && cacheMB > 0 {
+		cacheSize = int64(cacheMB) * 1024 * 1024
+	}
+
 	return Config{
-		Vendor:      vendor,
-		URL:         url,
-		Token:       token,
-		Model:       model,
-		Prompt:      prompt,
-		Temperature: temp,
-		Timeout:     timeout,
+		Vendor:          vendor,
+		URL:             url,
+		Token:           token,
+		Model:           model,
+		Prompt:          prompt,
+		Temperature:     temp,
+		Timeout:         timeout,
+		MaxTokens:       maxTokens,
+		ContextStrategy: strategy,
+		SummaryModel:    summaryModel,
+		EmbedURL:        embedURL,
+		EmbedModel:      embedModel,
+		CacheTTL:        cacheTTL,
+		CacheSize:       cacheSize,
 	}, nil
 }
+
+// defaultEmbedURL returns the embeddings endpoint
+// next to the chat endpoint with the given URL,
+// or an empty string if the URL is not a standard one.
+func defaultEmbedURL(vendor, url string) string {
+	switch vendor {
+	case "openai":
+		if base, ok := strings.CutSuffix(url, "/chat/completions"); ok {
+			return base + "/embeddings"
+		}
+	case "ollama":
+		if base, ok := strings.CutSuffix(url, "/api/chat"); ok {
+			return base + "/api/embed"
+		}
+	}
+	return ""
+}
+
+// summaryConfig returns the configuration
+// to summarize the conversation history.
+func (c Config) summaryConfig() Config {
+	config := c
+	config.Model = c.SummaryModel
+	config.Temperature = 0
+	return config
+}
@@ -35,13 +35,19 @@ func Test_loadConfig(t *testing.T) {
 				os.Clearenv()
 			},
 			want: Config{
-				Vendor:      defaultVendor,
-				URL:         openAIURL,
-				Token:       "",
-				Model:       defaultModel,
-				Prompt:      "", // This will be set in the test
-				Temperature: defaultTemperature,
-				Timeout:     defaultTimeout,
+				Vendor:          defaultVendor,
+				URL:             openAIURL,
+				Token:           "",
+				Model:           defaultModel,
+				Prompt:          "", // This will be set in the test
+				Temperature:     defaultTemperature,
+				Timeout:         defaultTimeout,
+				MaxTokens:       defaultOpenAIMaxTokens,
+				ContextStrategy: "drop",
+				SummaryModel:    defaultModel,
+				EmbedURL:        "https://api.openai.com/v1/embeddings",
+				CacheTTL:        defaultCacheTTL,
+				CacheSize:       defaultCacheSize,
 			},
 		},
 		{
@@ -54,15 +60,25 @@ func Test_loadConfig(t *testing.T) {
 				_ = os.Setenv("HOWTO_AI_PROMPT", "test_prompt")
 				_ = os.Setenv("HOWTO_AI_TEMPERATURE", "0.5")
 				_ = os.Setenv("HOWTO_AI_TIMEOUT", "60")
+				_ = os.Setenv("HOWTO_AI_MAX_TOKENS", "8000")
+				_ = os.Setenv("HOWTO_AI_CONTEXT", "summarize")
+				_ = os.Setenv("HOWTO_AI_SUMMARY_MODEL", "test_summary")
+				_ = os.Setenv("HOWTO_CACHE_TTL", "24")
+				_ = os.Setenv("HOWTO_CACHE_SIZE", "1")
 			},
 			want: Config{
-				Vendor:      "ollama",
-				URL:         "http://localhost:12345",
-				Token:       "test_token",
-				Model:       "test_model",
-				Prompt:      "test_prompt",
-				Temperature: 0.5,
-				Timeout:     60 * time.Second,
+				Vendor:          "ollama",
+				URL:             "http://localhost:12345",
+				Token:           "test_token",
+				Model:           "test_model",
+				Prompt:          "test_prompt",
+				Temperature:     0.5,
+				Timeout:         60 * time.Second,
+				MaxTokens:       8000,
+				ContextStrategy: "summarize",
+				SummaryModel:    "test_summary",
+				CacheTTL:        24 * time.Hour,
+				CacheSize:       1024 * 1024,
 			},
 		},
 		{
@@ -71,13 +87,19 @@ func Test_loadConfig(t *testing.T) {
 				_ = os.Setenv("HOWTO_AI_TEMPERATURE", "invalid")
 			},
 			want: Config{
-				Vendor:      defaultVendor,
-				URL:         openAIURL,
-				Token:       "",
-				Model:       defaultModel,
-				Prompt:      "", // This will be set in the test
-				Temperature: defaultTemperature,
-				Timeout:     defaultTimeout,
+				Vendor:          defaultVendor,
+				URL:             openAIURL,
+				Token:           "",
+				Model:           defaultModel,
+				Prompt:          "", // This will be set in the test
+				Temperature:     defaultTemperature,
+				Timeout:         defaultTimeout,
+				MaxTokens:       defaultOpenAIMaxTokens,
+				ContextStrategy: "drop",
+				SummaryModel:    defaultModel,
+				EmbedURL:        "https://api.openai.com/v1/embeddings",
+				CacheTTL:        defaultCacheTTL,
+				CacheSize:       defaultCacheSize,
 			},
 		},
 		{
@@ -86,15 +108,61 @@ func Test_loadConfig(t *testing.T) {
 				_ = os.Setenv("HOWTO_AI_TIMEOUT", "invalid")
 			},
 			want: Config{
-				Vendor:      defaultVendor,
-				URL:         openAIURL,
-				Token:       "",
-				Model:       defaultModel,
-				Prompt:      "", // This will be set in the test
-				Temperature: defaultTemperature,
-				Timeout:     defaultTimeout,
+				Vendor:          defaultVendor,
+				URL:             openAIURL,
+				Token:           "",
+				Model:           defaultModel,
+				Prompt:          "", // This will be set in the test
+				Temperature:     defaultTemperature,
+				Timeout:         defaultTimeout,
+				MaxTokens:       defaultOpenAIMaxTokens,
+				ContextStrategy: "drop",
+				SummaryModel:    defaultModel,
+				EmbedURL:        "https://api.openai.com/v1/embeddings",
+				CacheTTL:        defaultCacheTTL,
+				CacheSize:       defaultCacheSize,
 			},
 		},
+		{
+			name: "embedding model",
+			setupEnv: func() {
+				_ = os.Setenv("HOWTO_AI_VENDOR", "ollama")
+				_ = os.Setenv("HOWTO_AI_EMBED_MODEL", "nomic-embed-text")
+			},
+			want: Config{
+				Vendor:          "ollama",
+				URL:             ollamaURL,
+				Token:           "",
+				Model:           defaultModel,
+				Prompt:          "", // This will be set in the test
+				Temperature:     defaultTemperature,
+				Timeout:         defaultTimeout,
+				MaxTokens:       defaultOllamaMaxTokens,
+				ContextStrategy: "drop",
+				SummaryModel:    defaultModel,
+				EmbedURL:        "http://localhost:11434/api/embed",
+				EmbedModel:      "nomic-embed-text",
+				CacheTTL:        defaultCacheTTL,
+				CacheSize:       defaultCacheSize,
+			},
+		},
+		{
+			name: "embedding url unknown",
+			setupEnv: func() {
+				_ = os.Setenv("HOWTO_AI_URL", "http://localhost:12345")
+				_ = os.Setenv("HOWTO_AI_EMBED_MODEL", "text-embedding-3-small")
+			},
+			want:    Config{},
+			wantErr: "set HOWTO_AI_EMBED_URL to use the embedding model",
+		},
+		{
+			name: "unknown context strategy",
+			setupEnv: func() {
+				_ = os.Setenv("HOWTO_AI_CONTEXT", "forget")
+			},
+			want:    Config{},
+			wantErr: "unknown context strategy: forget",
+		},
 		{
 			name: "unknown vendor",
 			setupEnv: func() {
@@ -2,6 +2,7 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -18,7 +19,7 @@ type ollRequest struct {
 	Model    string     `json:"model"`
 	Options  ollOptions `json:"options"`
 	Stream   bool       `json:"stream"`
-	Messages []message  `json:"messages"`
+	Messages []Message  `json:"messages"`
 }
 
 // ollAnswer represents the response from the Ollama API.
@@ -28,15 +29,43 @@ type ollAnswer struct {
 	} `json:"message"`
 }
 
+// ollEmbedRequest represents the embeddings request sent to the Ollama API.
+type ollEmbedRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// ollEmbedding represents the embeddings response from the Ollama API.
+type ollEmbedding struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
 // ollama is an AI model that uses the Ollama API.
 type ollama struct {
 	config Config
 }
 
 // Ask sends a question to the AI and returns the answer.
-func (ai ollama) Ask(history []string) (string, error) {
+func (ai ollama) Ask(ctx context.Context, history []Message) (string, error) {
+	if prompt, ok := PromptFrom(ctx); ok {
+		ai.config.Prompt = prompt
+	}
+	summarizer := ollama{ai.config.summaryConfig()}
+	history, err := fitHistory(ctx, ai.config, summarizer.chat, history)
+	if err != nil {
+		return "", err
+	}
+
+	if temp, ok := TemperatureFrom(ctx); ok {
+		ai.config.Temperature = temp
+	}
 	messages := buildMessages(ai.config.Prompt, history)
-	req, err := ai.buildReq(messages)
+	return ai.chat(ctx, messages)
+}
+
+// chat sends the messages to the AI and returns the answer.
+func (ai ollama) chat(ctx context.Context, messages []Message) (string, error) {
+	req, err := ai.buildReq(ctx, messages)
 	if err != nil {
 		return "", err
 	}
@@ -50,7 +79,7 @@ func (ai ollama) Ask(history []string) (string, error) {
 }
 
 // buildReq constructs an HTTP request from the AI configuration and messages.
-func (ai ollama) buildReq(messages []message) (*http.Request, error) {
+func (ai ollama) buildReq(ctx context.Context, messages []Message) (*http.Request, error) {
 	reqBody := ollRequest{
 		Model:    ai.config.Model,
 		Options:  ollOptions{Temperature: ai.config.Temperature},
@@ -63,7 +92,7 @@ func (ai ollama) buildReq(messages []message) (*http.Request, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", ai.config.URL, bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", ai.config.URL, bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -97,3 +126,44 @@ func (ai ollama) parseAnswer(resp *http.Response) (string, error) {
 	content := ans.Message.Content
 	return strings.TrimSpace(content), nil
 }
+
+// Embed returns the embedding vector for the text.
+func (ai ollama) Embed(text string) ([]float32, error) {
+	req, err := ai.buildEmbedReq(text)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ai.fetchResp(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var emb ollEmbedding
+	err = json.NewDecoder(resp.Body).Decode(&emb)
+	if err != nil {
+		return nil, err
+	}
+	if len(emb.Embeddings) == 0 || len(emb.Embeddings[0]) == 0 {
+		return nil, fmt.Errorf("no embedding in response")
+	}
+	return emb.Embeddings[0], nil
+}
+
+// buildEmbedReq constructs an HTTP request to get the embedding of the text.
+func (ai ollama) buildEmbedReq(text string) (*http.Request, error) {
+	reqBody := ollEmbedRequest{Model: ai.config.EmbedModel, Input: text}
+	reqBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", ai.config.EmbedURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
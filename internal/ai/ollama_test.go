@@ -2,6 +2,7 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"testing"
@@ -20,7 +21,10 @@ func TestOllama_Ask(t *testing.T) {
 		Temperature: 0.7,
 		Timeout:     30 * time.Second,
 	}
-	history := []string{"Hello", "Hi there!"}
+	history := []Message{
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi there!"},
+	}
 
 	t.Run("successful", func(t *testing.T) {
 		httpClient = NewTestClient(func(req *http.Request) *http.Response {
@@ -33,8 +37,41 @@ func TestOllama_Ask(t *testing.T) {
 
 		ai := ollama{config}
 
-		answer, err := ai.Ask(history)
+		answer, err := ai.Ask(context.Background(), history)
 		be.Err(t, err, nil)
 		be.Equal(t, answer, "I'm doing great!")
 	})
 }
+
+func TestOllama_Embed(t *testing.T) {
+	config := Config{
+		Vendor:     "ollama",
+		EmbedURL:   "http://localhost:11434/api/embed",
+		EmbedModel: "nomic-embed-text",
+	}
+
+	t.Run("successful", func(t *testing.T) {
+		httpClient = NewTestClient(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"embeddings": [[0.1, 0.2, 0.3]]}`)),
+				Header:     make(http.Header),
+			}
+		})
+		vec, err := ollama{config}.Embed("hello")
+		be.Err(t, err, nil)
+		be.Equal(t, vec, []float32{0.1, 0.2, 0.3})
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		httpClient = NewTestClient(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"embeddings": []}`)),
+				Header:     make(http.Header),
+			}
+		})
+		_, err := ollama{config}.Embed("hello")
+		be.Err(t, err, "no embedding in response")
+	})
+}
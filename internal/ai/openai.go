@@ -2,6 +2,7 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,7 +14,7 @@ See https://github.com/nalgeon/howto#readme for details`)
 // oaiRequest represents the request sent to the OpenAI-compatible API.
 type oaiRequest struct {
 	Model       string    `json:"model"`
-	Messages    []message `json:"messages"`
+	Messages    []Message `json:"messages"`
 	Temperature float64   `json:"temperature"`
 }
 
@@ -26,19 +27,51 @@ type oaiAnswer struct {
 	} `json:"choices"`
 }
 
+// oaiEmbedRequest represents the embeddings request
+// sent to the OpenAI-compatible API.
+type oaiEmbedRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// oaiEmbedding represents the embeddings response
+// from the OpenAI-compatible API.
+type oaiEmbedding struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
 // openai is an AI model that uses the OpenAI-compatible API.
 type openai struct {
 	config Config
 }
 
 // Ask sends a question to the AI and returns the answer.
-func (ai openai) Ask(history []string) (string, error) {
+func (ai openai) Ask(ctx context.Context, history []Message) (string, error) {
 	if ai.config.Token == "" {
 		return "", errMissingToken
 	}
 
+	if prompt, ok := PromptFrom(ctx); ok {
+		ai.config.Prompt = prompt
+	}
+	summarizer := openai{ai.config.summaryConfig()}
+	history, err := fitHistory(ctx, ai.config, summarizer.chat, history)
+	if err != nil {
+		return "", err
+	}
+
+	if temp, ok := TemperatureFrom(ctx); ok {
+		ai.config.Temperature = temp
+	}
 	messages := buildMessages(ai.config.Prompt, history)
-	req, err := ai.buildReq(messages)
+	return ai.chat(ctx, messages)
+}
+
+// chat sends the messages to the AI and returns the answer.
+func (ai openai) chat(ctx context.Context, messages []Message) (string, error) {
+	req, err := ai.buildReq(ctx, messages)
 	if err != nil {
 		return "", err
 	}
@@ -52,7 +85,7 @@ func (ai openai) Ask(history []string) (string, error) {
 }
 
 // buildReq constructs an HTTP request from the AI configuration and messages.
-func (ai openai) buildReq(messages []message) (*http.Request, error) {
+func (ai openai) buildReq(ctx context.Context, messages []Message) (*http.Request, error) {
 	reqBody := oaiRequest{
 		Model:       ai.config.Model,
 		Messages:    messages,
@@ -64,7 +97,7 @@ func (ai openai) buildReq(messages []message) (*http.Request, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", ai.config.URL, bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", ai.config.URL, bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -103,3 +136,49 @@ func (ai openai) parseAnswer(resp *http.Response) (string, error) {
 
 	return "", fmt.Errorf("no answer")
 }
+
+// Embed returns the embedding vector for the text.
+func (ai openai) Embed(text string) ([]float32, error) {
+	if ai.config.Token == "" {
+		return nil, errMissingToken
+	}
+
+	req, err := ai.buildEmbedReq(text)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ai.fetchResp(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var emb oaiEmbedding
+	err = json.NewDecoder(resp.Body).Decode(&emb)
+	if err != nil {
+		return nil, err
+	}
+	if len(emb.Data) == 0 || len(emb.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("no embedding in response")
+	}
+	return emb.Data[0].Embedding, nil
+}
+
+// buildEmbedReq constructs an HTTP request to get the embedding of the text.
+func (ai openai) buildEmbedReq(text string) (*http.Request, error) {
+	reqBody := oaiEmbedRequest{Model: ai.config.EmbedModel, Input: text}
+	reqBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", ai.config.EmbedURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+ai.config.Token)
+
+	return req, nil
+}
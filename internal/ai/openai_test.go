@@ -2,6 +2,7 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -34,7 +35,10 @@ func TestOpenAI_Ask(t *testing.T) {
 		Timeout:     30 * time.Second,
 	}
 
-	history := []string{"Hello", "Hi there!"}
+	history := []Message{
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi there!"},
+	}
 
 	t.Run("successful", func(t *testing.T) {
 		httpClient = NewTestClient(func(req *http.Request) *http.Response {
@@ -47,14 +51,53 @@ func TestOpenAI_Ask(t *testing.T) {
 		})
 
 		ai := openai{config}
-		answer, err := ai.Ask(history)
+		answer, err := ai.Ask(context.Background(), history)
 		be.Err(t, err, nil)
 		be.Equal(t, answer, "I'm doing great!")
 	})
 
+	t.Run("temperature", func(t *testing.T) {
+		var reqBody oaiRequest
+		httpClient = NewTestClient(func(req *http.Request) *http.Response {
+			_ = json.NewDecoder(req.Body).Decode(&reqBody)
+			responseBody := `{"choices": [{"message": {"content": "I'm doing great!"}}]}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(responseBody)),
+				Header:     make(http.Header),
+			}
+		})
+
+		ai := openai{config}
+		ctx := WithTemperature(context.Background(), 1.5)
+		_, err := ai.Ask(ctx, history)
+		be.Err(t, err, nil)
+		be.Equal(t, reqBody.Temperature, 1.5)
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		var reqBody oaiRequest
+		httpClient = NewTestClient(func(req *http.Request) *http.Response {
+			_ = json.NewDecoder(req.Body).Decode(&reqBody)
+			responseBody := `{"choices": [{"message": {"content": "I'm doing great!"}}]}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(responseBody)),
+				Header:     make(http.Header),
+			}
+		})
+
+		ai := openai{config}
+		ctx := WithPrompt(context.Background(), "Reply with a plan.")
+		_, err := ai.Ask(ctx, history)
+		be.Err(t, err, nil)
+		be.Equal(t, reqBody.Messages[0], Message{Role: "system", Content: "Reply with a plan."})
+		be.Equal(t, len(reqBody.Messages), 3)
+	})
+
 	t.Run("missing token", func(t *testing.T) {
 		ai := openai{Config{Token: ""}}
-		_, err := ai.Ask([]string{})
+		_, err := ai.Ask(context.Background(), []Message{})
 		be.Err(t, err, errMissingToken)
 	})
 
@@ -69,7 +112,7 @@ func TestOpenAI_Ask(t *testing.T) {
 		})
 
 		ai := openai{config}
-		_, err := ai.Ask(history)
+		_, err := ai.Ask(context.Background(), history)
 		be.Err(t, err, "http status: 500 Internal Server Error")
 	})
 
@@ -83,7 +126,7 @@ func TestOpenAI_Ask(t *testing.T) {
 		})
 
 		ai := openai{config}
-		_, err := ai.Ask(history)
+		_, err := ai.Ask(context.Background(), history)
 		be.Err(t, err, "invalid character")
 	})
 
@@ -98,7 +141,7 @@ func TestOpenAI_Ask(t *testing.T) {
 		})
 
 		ai := openai{config}
-		_, err := ai.Ask(history)
+		_, err := ai.Ask(context.Background(), history)
 		be.Err(t, err, "no answer")
 	})
 }
@@ -114,9 +157,9 @@ func TestOpenAI_buildReq(t *testing.T) {
 		Timeout:     30 * time.Second,
 	}
 	ai := openai{config}
-	messages := []message{{Role: "user", Content: "hello"}}
+	messages := []Message{{Role: "user", Content: "hello"}}
 
-	req, err := ai.buildReq(messages)
+	req, err := ai.buildReq(context.Background(), messages)
 	be.Err(t, err, nil)
 	be.Equal(t, req.Method, http.MethodPost)
 	be.Equal(t, req.URL.String(), config.URL)
@@ -137,3 +180,47 @@ func TestOpenAI_buildReq(t *testing.T) {
 	}
 	be.Equal(t, requestBody, expectedRequestBody)
 }
+
+func TestOpenAI_Embed(t *testing.T) {
+	config := Config{
+		Vendor:     "openai",
+		Token:      "test_token",
+		EmbedURL:   "https://test.com/v1/embeddings",
+		EmbedModel: "text-embedding-3-small",
+	}
+
+	t.Run("successful", func(t *testing.T) {
+		httpClient = NewTestClient(func(req *http.Request) *http.Response {
+			var body oaiEmbedRequest
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			be.Equal(t, req.URL.String(), "https://test.com/v1/embeddings")
+			be.Equal(t, body, oaiEmbedRequest{Model: "text-embedding-3-small", Input: "hello"})
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"data": [{"embedding": [0.1, 0.2, 0.3]}]}`)),
+				Header:     make(http.Header),
+			}
+		})
+		vec, err := openai{config}.Embed("hello")
+		be.Err(t, err, nil)
+		be.Equal(t, vec, []float32{0.1, 0.2, 0.3})
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		_, err := openai{Config{}}.Embed("hello")
+		be.Err(t, err, errMissingToken)
+	})
+
+	t.Run("http error", func(t *testing.T) {
+		httpClient = NewTestClient(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Status:     "404 Not Found",
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+				Header:     make(http.Header),
+			}
+		})
+		_, err := openai{config}.Embed("hello")
+		be.Err(t, err, "http status: 404 Not Found")
+	})
+}
@@ -0,0 +1,367 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// Name of the file containing the archive of all questions and answers.
+const archiveFileName = "howto-archive.jsonl"
+
+// Number of entries shown by -log by default.
+const defaultLogSize = 20
+
+// Size of the chunks to read the end of the archive with.
+var archiveChunkSize = 64 * 1024
+
+// Archive record types.
+const (
+	recordAnswer = "answer"
+	recordRun    = "run"
+)
+
+// errNoArchive is returned when the archive is not available
+// (the history is transient).
+var errNoArchive = errors.New("archive is not available")
+
+// archiveRecord is a single line in the archive file.
+// The archive is append-only: answers are never changed,
+// runs are recorded as separate records referring to the answer.
+type archiveRecord struct {
+	Type string    `json:"type"`
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Answer records.
+	Question string `json:"question,omitempty"`
+	Answer   string `json:"answer,omitempty"`
+	Vendor   string `json:"vendor,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Cwd      string `json:"cwd,omitempty"`
+	// Run records.
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+// archiveEntry is a question and answer from the archive,
+// along with the result of the last run of the suggested command.
+type archiveEntry struct {
+	id       int
+	time     time.Time
+	question string
+	answer   string
+	vendor   string
+	model    string
+	cwd      string
+	runs     int
+	exitCode int
+}
+
+// command returns the command suggested in the answer.
+func (e archiveEntry) command() string {
+	cmd, _, _ := strings.Cut(e.answer, "\n")
+	return cmd
+}
+
+// title returns the question without the attached context.
+func (e archiveEntry) title() string {
+	title, _, _ := strings.Cut(e.question, "\n")
+	return title
+}
+
+// archiveAnswer appends the question and answer to the archive
+// and returns the ID of the new entry. Does nothing if the directory
+// is empty (the history is transient).
+func archiveAnswer(dir string, question, answer message) (int, error) {
+	if dir == "" {
+		return 0, nil
+	}
+	rec := archiveRecord{
+		Type:     recordAnswer,
+		Time:     answer.Time,
+		Question: question.Content,
+		Answer:   answer.Content,
+		Vendor:   answer.Vendor,
+		Model:    answer.Model,
+		Cwd:      question.Cwd,
+	}
+	path := filepath.Join(dir, archiveFileName)
+	err := withLock(path, func() error {
+		lastID, err := lastAnswerID(path)
+		if err != nil {
+			return err
+		}
+		rec.ID = lastID + 1
+		return appendRecord(path, rec)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("archive answer: %w", err)
+	}
+	return rec.ID, nil
+}
+
+// archiveRun records the exit code of the command suggested
+// in the archive entry with the given ID.
+func archiveRun(dir string, id int, exitCode int) error {
+	if dir == "" || id == 0 {
+		return nil
+	}
+	rec := archiveRecord{Type: recordRun, ID: id, Time: time.Now(), ExitCode: exitCode}
+	path := filepath.Join(dir, archiveFileName)
+	err := withLock(path, func() error {
+		return appendRecord(path, rec)
+	})
+	if err != nil {
+		return fmt.Errorf("archive run: %w", err)
+	}
+	return nil
+}
+
+// appendRecord appends the record to the archive file.
+func appendRecord(path string, rec archiveRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return appendLine(path, data)
+}
+
+// appendLine appends the line to the file, creating it if necessary.
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readArchive reads all entries from the archive, oldest first.
+func readArchive(dir string) ([]archiveEntry, error) {
+	if dir == "" {
+		return nil, errNoArchive
+	}
+	path := filepath.Join(dir, archiveFileName)
+	var records []archiveRecord
+	err := withLock(path, func() error {
+		var err error
+		records, err = readArchiveRecords(path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+
+	var entries []archiveEntry
+	index := map[int]int{}
+	for _, rec := range records {
+		switch rec.Type {
+		case recordAnswer:
+			index[rec.ID] = len(entries)
+			entries = append(entries, archiveEntry{
+				id:       rec.ID,
+				time:     rec.Time,
+				question: rec.Question,
+				answer:   rec.Answer,
+				vendor:   rec.Vendor,
+				model:    rec.Model,
+				cwd:      rec.Cwd,
+			})
+		case recordRun:
+			if i, ok := index[rec.ID]; ok {
+				entries[i].runs++
+				entries[i].exitCode = rec.ExitCode
+			}
+		}
+	}
+	return entries, nil
+}
+
+// lastAnswerID returns the ID of the last answer in the archive file,
+// or 0 if there are no answers. The IDs grow with each answer, so it's
+// also the largest one. Reads the file from the end, so that adding
+// an answer takes the same time no matter how large the archive is.
+func lastAnswerID(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// The beginning of the line that continues in the previous chunk.
+	var partial []byte
+	for end := stat.Size(); end > 0; {
+		start := max(end-int64(archiveChunkSize), 0)
+		chunk := make([]byte, end-start, end-start+int64(len(partial)))
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		lines := bytes.Split(append(chunk, partial...), []byte("\n"))
+		first := 0
+		if start > 0 {
+			// The first line is incomplete, read the rest with the next chunk.
+			partial, first = lines[0], 1
+		}
+		for i := len(lines) - 1; i >= first; i-- {
+			var rec archiveRecord
+			if json.Unmarshal(lines[i], &rec) == nil && rec.Type == recordAnswer && rec.ID > 0 {
+				return rec.ID, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// readArchiveRecords reads all records from the archive file.
+// Skips malformed lines, so that a single broken record
+// does not make the whole archive unreadable.
+func readArchiveRecords(path string) ([]archiveRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var records []archiveRecord
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec archiveRecord
+			if json.Unmarshal(line, &rec) == nil && rec.ID > 0 {
+				records = append(records, rec)
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// findEntry returns the archive entry with the given ID.
+func findEntry(entries []archiveEntry, idStr string) (archiveEntry, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(idStr, "#"))
+	if err != nil {
+		return archiveEntry{}, fmt.Errorf("invalid entry id: %s", idStr)
+	}
+	for _, entry := range entries {
+		if entry.id == id {
+			return entry, nil
+		}
+	}
+	return archiveEntry{}, fmt.Errorf("entry not found: %d", id)
+}
+
+// searchArchive returns the entries whose question or answer
+// contains all the words from the query (case-insensitive).
+func searchArchive(entries []archiveEntry, query string) []archiveEntry {
+	words := strings.Fields(strings.ToLower(query))
+	var found []archiveEntry
+	for _, entry := range entries {
+		text := strings.ToLower(entry.title() + "\n" + entry.answer)
+		matched := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// printLog prints the most recent entries from the archive.
+// Prints the given number of entries (or the default number if empty).
+func printLog(out io.Writer, history *History, arg string) error {
+	n := defaultLogSize
+	if arg != "" {
+		var err error
+		n, err = strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of entries: %s", arg)
+		}
+	}
+	entries, err := readArchive(history.dir)
+	if err != nil {
+		return err
+	}
+	printEntries(out, entries[max(len(entries)-n, 0):])
+	return nil
+}
+
+// printSearch prints the archive entries matching the query.
+func printSearch(out io.Writer, history *History, query string) error {
+	entries, err := readArchive(history.dir)
+	if err != nil {
+		return err
+	}
+	printEntries(out, searchArchive(entries, query))
+	return nil
+}
+
+// printEntries prints the archive entries: the entry ID and
+// the suggested command, followed by the time, the question,
+// and the result of the last run (if any).
+func printEntries(out io.Writer, entries []archiveEntry) {
+	if len(entries) == 0 {
+		fprintln(out, "(empty)")
+		return
+	}
+	width := terminalWidth()
+	for _, entry := range entries {
+		fprintln(out, bold("#"+strconv.Itoa(entry.id)), highlight(entry.command()))
+		info := entry.time.Local().Format("2006-01-02 15:04") + " · " + entry.title()
+		if entry.runs > 0 {
+			info += fmt.Sprintf(" · ran (exit %d)", entry.exitCode)
+		}
+		fprintln(out, truncate("  "+info, width))
+	}
+}
+
+// runArchived runs the command suggested in the archive entry
+// with the given ID and records the result in the archive.
+func runArchived(out io.Writer, editor *lineEditor, ask ai.AskFunc, history *History, idStr string) error {
+	entries, err := readArchive(history.dir)
+	if err != nil {
+		return err
+	}
+	entry, err := findEntry(entries, idStr)
+	if err != nil {
+		return err
+	}
+	cmd := entry.command()
+	if cmd == "" {
+		return fmt.Errorf("no command to run")
+	}
+	src := runSource{id: entry.id, question: entry.question, vendor: entry.vendor, model: entry.model}
+	return runConfirmed(out, editor, ask, history, src, cmd)
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_archiveAnswer(t *testing.T) {
+	t.Run("append", func(t *testing.T) {
+		dir := t.TempDir()
+		id, err := archiveAnswer(dir, newQuestion("untar"), newAnswer("tar -xzf a.tgz -C dir"))
+		be.Err(t, err, nil)
+		be.Equal(t, id, 1)
+		id, err = archiveAnswer(dir, newQuestion("list files"), newAnswer("ls -l"))
+		be.Err(t, err, nil)
+		be.Equal(t, id, 2)
+
+		entries, err := readArchive(dir)
+		be.Err(t, err, nil)
+		be.Equal(t, len(entries), 2)
+		be.Equal(t, entries[0].question, "untar")
+		be.Equal(t, entries[0].command(), "tar -xzf a.tgz -C dir")
+		be.Equal(t, entries[1].id, 2)
+	})
+	t.Run("transient", func(t *testing.T) {
+		id, err := archiveAnswer("", newQuestion("untar"), newAnswer("tar -xzf a.tgz"))
+		be.Err(t, err, nil)
+		be.Equal(t, id, 0)
+	})
+}
+
+func Test_lastAnswerID(t *testing.T) {
+	defer func(size int) { archiveChunkSize = size }(archiveChunkSize)
+	archiveChunkSize = 16
+	path := filepath.Join(t.TempDir(), archiveFileName)
+
+	t.Run("not exists", func(t *testing.T) {
+		id, err := lastAnswerID(path)
+		be.Err(t, err, nil)
+		be.Equal(t, id, 0)
+	})
+	t.Run("runs after answers", func(t *testing.T) {
+		data := `{"type":"answer","id":1,"question":"q1","answer":"a1"}
+{"type":"answer","id":2,"question":"q2","answer":"a2"}
+{"type":"run","id":1,"exit_code":1}
+{"type":"run","id":1}
+`
+		be.Err(t, os.WriteFile(path, []byte(data), 0600), nil)
+		id, err := lastAnswerID(path)
+		be.Err(t, err, nil)
+		be.Equal(t, id, 2)
+	})
+	t.Run("malformed line", func(t *testing.T) {
+		data := `{"type":"answer","id":1,"question":"q1","answer":"a1"}
+{"type":"answer","id":2,"quest`
+		be.Err(t, os.WriteFile(path, []byte(data), 0600), nil)
+		id, err := lastAnswerID(path)
+		be.Err(t, err, nil)
+		be.Equal(t, id, 1)
+	})
+	t.Run("no answers", func(t *testing.T) {
+		be.Err(t, os.WriteFile(path, []byte(`{"type":"run","id":1}`+"\n"), 0600), nil)
+		id, err := lastAnswerID(path)
+		be.Err(t, err, nil)
+		be.Equal(t, id, 0)
+	})
+}
+
+func Test_archiveRun(t *testing.T) {
+	dir := t.TempDir()
+	id, err := archiveAnswer(dir, newQuestion("fail"), newAnswer("false"))
+	be.Err(t, err, nil)
+
+	err = archiveRun(dir, id, 0)
+	be.Err(t, err, nil)
+	err = archiveRun(dir, id, 1)
+	be.Err(t, err, nil)
+
+	entries, err := readArchive(dir)
+	be.Err(t, err, nil)
+	be.Equal(t, entries[0].runs, 2)
+	be.Equal(t, entries[0].exitCode, 1)
+}
+
+func Test_readArchive(t *testing.T) {
+	t.Run("not exists", func(t *testing.T) {
+		entries, err := readArchive(t.TempDir())
+		be.Err(t, err, nil)
+		be.Equal(t, len(entries), 0)
+	})
+	t.Run("transient", func(t *testing.T) {
+		_, err := readArchive("")
+		be.Err(t, err, errNoArchive)
+	})
+	t.Run("malformed line", func(t *testing.T) {
+		dir := t.TempDir()
+		data := `{"type":"answer","id":1,"question":"q1","answer":"a1"}
+{"type":"answer","id":2,"quest
+{"type":"answer","id":3,"question":"q3","answer":"a3"}
+`
+		err := os.WriteFile(filepath.Join(dir, archiveFileName), []byte(data), 0600)
+		be.Err(t, err, nil)
+		entries, err := readArchive(dir)
+		be.Err(t, err, nil)
+		be.Equal(t, len(entries), 2)
+		be.Equal(t, entries[1].id, 3)
+	})
+}
+
+func Test_searchArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{id: 1, question: "how to untar into a directory", answer: "tar -xzf a.tgz -C dir"},
+		{id: 2, question: "list files", answer: "ls -l"},
+		{id: 3, question: "extract archive", answer: "tar -xf a.tar\n\nExtracts into the current directory."},
+	}
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"tar", []int{1, 3}},
+		{"TAR directory", []int{1, 3}},
+		{"untar", []int{1}},
+		{"rsync", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var got []int
+			for _, entry := range searchArchive(entries, tt.query) {
+				got = append(got, entry.id)
+			}
+			be.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func Test_findEntry(t *testing.T) {
+	entries := []archiveEntry{{id: 1}, {id: 5}}
+	entry, err := findEntry(entries, "5")
+	be.Err(t, err, nil)
+	be.Equal(t, entry.id, 5)
+	entry, err = findEntry(entries, "#1")
+	be.Err(t, err, nil)
+	be.Equal(t, entry.id, 1)
+	_, err = findEntry(entries, "3")
+	be.Err(t, err, "entry not found: 3")
+	_, err = findEntry(entries, "last")
+	be.Err(t, err, "invalid entry id: last")
+}
+
+func TestHowto_archive(t *testing.T) {
+	defer setColor(false)()
+	ver := NewVersion("1.2.3", "commit", "now")
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		question := history[len(history)-1].Content
+		return "echo " + question + "\n\nPrints " + question + ".", nil
+	}
+	dir := t.TempDir()
+	history, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+
+	// Every answer is archived, even though the history is cleared.
+	out := &bytes.Buffer{}
+	err = Howto(nil, out, ask, nil, ver, []string{"hello"}, history)
+	be.Err(t, err, nil)
+	err = Howto(nil, out, ask, nil, ver, []string{"-run"}, history)
+	be.Err(t, err, nil)
+	err = Howto(nil, out, ask, nil, ver, []string{"world"}, history)
+	be.Err(t, err, nil)
+
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-log"}, history)
+	be.Err(t, err, nil)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	be.Equal(t, len(lines), 4)
+	be.Equal(t, lines[0], "#1 echo hello")
+	be.True(t, strings.HasSuffix(lines[1], " · hello · ran (exit 0)"))
+	be.Equal(t, lines[2], "#2 echo world")
+	be.True(t, strings.HasSuffix(lines[3], " · world"))
+
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-log", "1"}, history)
+	be.Err(t, err, nil)
+	be.True(t, strings.HasPrefix(out.String(), "#2 echo world\n"))
+
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-search", "HELLO"}, history)
+	be.Err(t, err, nil)
+	be.True(t, strings.HasPrefix(out.String(), "#1 echo hello\n"))
+	be.True(t, !strings.Contains(out.String(), "world"))
+
+	// Run an old command.
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-run", "1"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, out.String(), "echo hello\n\nhello\n")
+
+	entries, err := readArchive(dir)
+	be.Err(t, err, nil)
+	be.Equal(t, entries[0].runs, 2)
+	be.Equal(t, entries[1].runs, 0)
+
+	err = Howto(nil, out, ask, nil, ver, []string{"-run", "42"}, history)
+	be.Err(t, err, "entry not found: 42")
+	err = Howto(nil, out, ask, nil, ver, []string{"-log", "zero"}, history)
+	be.Err(t, err, "invalid number of entries: zero")
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// options describes the parsed command-line arguments.
+type options struct {
+	// Command to execute (-h, -v, -run, etc.),
+	// or an empty string to ask a question.
+	command string
+	// Command argument (e.g. session name for -show).
+	arg string
+	// Files to attach as context (-f).
+	files []string
+	// Session to switch to (-s).
+	session string
+	// File to save the plan to (-o).
+	output string
+	// Do not use the answer cache (--no-cache).
+	noCache bool
+	// Do not show the action menu after the answer (--no-menu).
+	noMenu bool
+	// Run commands in the sandbox (--sandbox).
+	sandbox bool
+	// Do not read the piped input (--no-stdin).
+	noStdin bool
+	// Question to ask.
+	question string
+}
+
+// commands lists the commands that take no arguments
+// and must be used on their own.
+var commands = map[string]string{
+	"-h":           "-h",
+	"--help":       "-h",
+	"-v":           "-v",
+	"--version":    "-v",
+	"-i":           "-i",
+	"-undo":        "-undo",
+	"-copy":        "-copy",
+	"-sessions":    "-sessions",
+	"-clear-cache": "-clear-cache",
+}
+
+// argCommands lists the commands that take an argument,
+// and whether the argument is required.
+var argCommands = map[string]bool{
+	"-run":    false,
+	"-show":   false,
+	"-delete": true,
+	"-log":    false,
+	"-search": true,
+	"-export": true,
+	"-retry":  false,
+	"-branch": true,
+	"-plan":   true,
+}
+
+// parseArgs parses the command-line arguments.
+// Options come first, followed by a command or a question.
+func parseArgs(args []string) (options, error) {
+	var opts options
+
+loop:
+	for len(args) > 0 {
+		switch args[0] {
+		case "-f":
+			if len(args) < 2 {
+				return options{}, fmt.Errorf("-f requires a file path")
+			}
+			opts.files = append(opts.files, args[1])
+			args = args[2:]
+		case "-s":
+			if len(args) < 2 {
+				return options{}, fmt.Errorf("-s requires a session name")
+			}
+			opts.session = args[1]
+			args = args[2:]
+		case "-o":
+			if len(args) < 2 {
+				return options{}, fmt.Errorf("-o requires a file path")
+			}
+			opts.output = args[1]
+			args = args[2:]
+		case "--no-cache":
+			opts.noCache = true
+			args = args[1:]
+		case "--sandbox":
+			opts.sandbox = true
+			args = args[1:]
+		case "--no-menu":
+			opts.noMenu = true
+			args = args[1:]
+		case "--no-stdin":
+			opts.noStdin = true
+			args = args[1:]
+		default:
+			break loop
+		}
+	}
+
+	if opts.output != "" && (len(args) == 0 || args[0] != "-plan") {
+		return options{}, fmt.Errorf("-o can only be used with -plan")
+	}
+
+	input := strings.Join(args, " ")
+	if cmd, ok := commands[input]; ok {
+		opts.command = cmd
+		return opts, nil
+	}
+
+	if len(args) > 0 {
+		if required, ok := argCommands[args[0]]; ok {
+			opts.command = args[0]
+			opts.arg = strings.Join(args[1:], " ")
+			if required && opts.arg == "" {
+				return options{}, fmt.Errorf("%s requires an argument", opts.command)
+			}
+			return opts, nil
+		}
+	}
+
+	opts.question = input
+	return opts, nil
+}
+
+// stdin returns the input to read the piped context from,
+// or nil if the piped input should be ignored.
+func (o options) stdin(in io.Reader) io.Reader {
+	if o.noStdin {
+		return nil
+	}
+	return in
+}
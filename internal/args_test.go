@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_parseArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want options
+	}{
+		{
+			name: "help",
+			args: []string{"--help"},
+			want: options{command: "-h"},
+		},
+		{
+			name: "version",
+			args: []string{"-v"},
+			want: options{command: "-v"},
+		},
+		{
+			name: "run",
+			args: []string{"-run"},
+			want: options{command: "-run"},
+		},
+		{
+			name: "run archived",
+			args: []string{"-run", "12"},
+			want: options{command: "-run", arg: "12"},
+		},
+		{
+			name: "log",
+			args: []string{"-log", "5"},
+			want: options{command: "-log", arg: "5"},
+		},
+		{
+			name: "search",
+			args: []string{"-search", "untar", "directory"},
+			want: options{command: "-search", arg: "untar directory"},
+		},
+		{
+			name: "no cache",
+			args: []string{"--no-cache", "list", "files"},
+			want: options{noCache: true, question: "list files"},
+		},
+		{
+			name: "no stdin",
+			args: []string{"--no-stdin", "-f", "a.txt", "explain"},
+			want: options{noStdin: true, files: []string{"a.txt"}, question: "explain"},
+		},
+		{
+			name: "clear cache",
+			args: []string{"-clear-cache"},
+			want: options{command: "-clear-cache"},
+		},
+		{
+			name: "export",
+			args: []string{"-export", "md", "deploy"},
+			want: options{command: "-export", arg: "md deploy"},
+		},
+		{
+			name: "interactive",
+			args: []string{"-s", "work", "-i"},
+			want: options{command: "-i", session: "work"},
+		},
+		{
+			name: "no menu",
+			args: []string{"--no-menu", "list", "files"},
+			want: options{noMenu: true, question: "list files"},
+		},
+		{
+			name: "copy",
+			args: []string{"-copy"},
+			want: options{command: "-copy"},
+		},
+		{
+			name: "plan",
+			args: []string{"-o", "setup.sh", "-plan", "create", "a", "user"},
+			want: options{command: "-plan", arg: "create a user", output: "setup.sh"},
+		},
+		{
+			name: "sandbox",
+			args: []string{"--sandbox", "-run", "3"},
+			want: options{command: "-run", arg: "3", sandbox: true},
+		},
+		{
+			name: "undo",
+			args: []string{"-undo"},
+			want: options{command: "-undo"},
+		},
+		{
+			name: "retry",
+			args: []string{"-retry", "0.7"},
+			want: options{command: "-retry", arg: "0.7"},
+		},
+		{
+			name: "branch",
+			args: []string{"-branch", "2", "fork"},
+			want: options{command: "-branch", arg: "2 fork"},
+		},
+		{
+			name: "question",
+			args: []string{"list", "files"},
+			want: options{question: "list files"},
+		},
+		{
+			name: "follow up",
+			args: []string{"+", "sort", "by", "size"},
+			want: options{question: "+ sort by size"},
+		},
+		{
+			name: "command as part of question",
+			args: []string{"-v", "means", "verbose?"},
+			want: options{question: "-v means verbose?"},
+		},
+		{
+			name: "files",
+			args: []string{"-f", "Makefile", "-f", "go.mod", "build", "it"},
+			want: options{files: []string{"Makefile", "go.mod"}, question: "build it"},
+		},
+		{
+			name: "session",
+			args: []string{"-s", "deploy", "+", "now", "restart"},
+			want: options{session: "deploy", question: "+ now restart"},
+		},
+		{
+			name: "sessions",
+			args: []string{"-sessions"},
+			want: options{command: "-sessions"},
+		},
+		{
+			name: "show current",
+			args: []string{"-show"},
+			want: options{command: "-show"},
+		},
+		{
+			name: "show named",
+			args: []string{"-show", "deploy"},
+			want: options{command: "-show", arg: "deploy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgs(tt.args)
+			be.Err(t, err, nil)
+			be.Equal(t, got, tt.want)
+		})
+	}
+
+	t.Run("missing file path", func(t *testing.T) {
+		_, err := parseArgs([]string{"-f"})
+		be.Err(t, err, "-f requires a file path")
+	})
+
+	t.Run("missing session name", func(t *testing.T) {
+		_, err := parseArgs([]string{"-s"})
+		be.Err(t, err, "-s requires a session name")
+	})
+
+	t.Run("output without plan", func(t *testing.T) {
+		_, err := parseArgs([]string{"-o", "setup.sh", "list", "files"})
+		be.Err(t, err, "-o can only be used with -plan")
+	})
+
+	t.Run("missing argument", func(t *testing.T) {
+		_, err := parseArgs([]string{"-delete"})
+		be.Err(t, err, "-delete requires an argument")
+	})
+}
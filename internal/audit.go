@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Name of the file containing the audit log of the executed commands.
+const auditFileName = "howto-audit.jsonl"
+
+// runSource describes where the command to run came from.
+type runSource struct {
+	// ID of the archive entry with the answer (0 if none).
+	id int
+	// Question that produced the command.
+	question string
+	// AI vendor and model that suggested the command.
+	vendor string
+	model  string
+	// Whether the user edited the suggested command before running it.
+	edited bool
+}
+
+// lastSource returns the source of the command
+// suggested in the last answer.
+func lastSource(history *History) (runSource, bool) {
+	for i := len(history.messages) - 1; i >= 0; i-- {
+		msg := history.messages[i]
+		if msg.Role != roleAssistant {
+			continue
+		}
+		src := runSource{id: msg.ID, vendor: msg.Vendor, model: msg.Model}
+		if i > 0 {
+			src.question = history.messages[i-1].Content
+		}
+		return src, true
+	}
+	return runSource{}, false
+}
+
+// Audit record events.
+const (
+	auditStarted  = "start"
+	auditFinished = "finish"
+)
+
+// auditRecord is a single line in the audit log.
+// The audit log is append-only: each executed command gets
+// a record before it starts and another one after it finishes,
+// so that the command is logged even if howto is killed
+// while it runs. Both records have the same run ID.
+type auditRecord struct {
+	Event    string    `json:"event"`
+	RunID    string    `json:"run_id"`
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	Cwd      string    `json:"cwd"`
+	Question string    `json:"question,omitempty"`
+	Vendor   string    `json:"vendor,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Command  string    `json:"command"`
+	Edited   bool      `json:"edited,omitempty"`
+	Sandbox  bool      `json:"sandbox,omitempty"`
+	// Finish records only.
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	DurationMs *int64 `json:"duration_ms,omitempty"`
+}
+
+// newAuditRecord creates a start record for the command.
+// Leaves out the context attached to the question,
+// and redacts the secrets in the question and the command.
+func newAuditRecord(src runSource, cmd string) auditRecord {
+	redactor, err := newRedactor(os.Getenv("HOWTO_REDACT"), nil)
+	if err != nil {
+		// Invalid user patterns are reported when asking,
+		// the built-in ones are still useful.
+		redactor, _ = newRedactor("", nil)
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	question, _ := parseQuestion(src.question)
+	rec := auditRecord{
+		Event:    auditStarted,
+		RunID:    hex.EncodeToString(id),
+		Time:     time.Now(),
+		User:     currentUser(),
+		Question: redactor.redact(strings.TrimSpace(question)),
+		Vendor:   src.vendor,
+		Model:    src.model,
+		Command:  redactor.redact(cmd),
+		Edited:   src.edited,
+		Sandbox:  sandbox,
+	}
+	rec.Host, _ = os.Hostname()
+	rec.Cwd, _ = os.Getwd()
+	return rec
+}
+
+// auditSyslogEnabled reports whether to send the audit records
+// to the system log (HOWTO_AUDIT_SYSLOG).
+func auditSyslogEnabled() bool {
+	switch strings.ToLower(os.Getenv("HOWTO_AUDIT_SYSLOG")) {
+	case "on", "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+// auditStart records that the command is about to run,
+// and returns the record to finish later with auditFinish.
+func auditStart(dir string, src runSource, cmd string) (auditRecord, error) {
+	rec := newAuditRecord(src, cmd)
+	return rec, writeAudit(dir, rec)
+}
+
+// auditFinish records the result of the command started with auditStart.
+func auditFinish(dir string, rec auditRecord, result *runResult) error {
+	rec.Event = auditFinished
+	rec.Time = time.Now()
+	code := result.ExitCode
+	ms := result.Duration.Milliseconds()
+	rec.ExitCode, rec.DurationMs = &code, &ms
+	return writeAudit(dir, rec)
+}
+
+// writeAudit appends the record to the audit log in the directory,
+// and sends it to the system log if enabled. Does not write the audit
+// log file if the directory is empty (the history is transient).
+func writeAudit(dir string, rec auditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("audit run: %w", err)
+	}
+	if dir != "" {
+		path := filepath.Join(dir, auditFileName)
+		err := withLock(path, func() error {
+			return appendLine(path, data)
+		})
+		if err != nil {
+			return fmt.Errorf("audit run: %w", err)
+		}
+	}
+	if auditSyslogEnabled() {
+		if err := writeSyslog(string(data)); err != nil {
+			return fmt.Errorf("audit run: syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+// currentUser returns the name of the user running howto.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// clipboardTools lists the command-line clipboard tools in order
+// of preference, along with the environment variable that must be set
+// for the tool to work (if any).
+var clipboardTools = []struct {
+	name string
+	args []string
+	env  string
+}{
+	{"wl-copy", nil, "WAYLAND_DISPLAY"},
+	{"xclip", []string{"-selection", "clipboard"}, "DISPLAY"},
+	{"xsel", []string{"--clipboard", "--input"}, "DISPLAY"},
+	{"pbcopy", nil, ""},
+	{"clip.exe", nil, ""},
+}
+
+// copyEnabled reports whether to copy every suggested command
+// to the clipboard (HOWTO_COPY).
+func copyEnabled() bool {
+	switch strings.ToLower(os.Getenv("HOWTO_COPY")) {
+	case "on", "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+// copyCommand copies the last suggested command to the clipboard.
+func copyCommand(out io.Writer, history *History) error {
+	cmd := history.LastCommand()
+	if cmd == "" {
+		return fmt.Errorf("no command to copy")
+	}
+	if ph := placeholderRe.FindString(cmd); ph != "" {
+		return fmt.Errorf("the command contains a redacted secret (%s), copy it from the answer", ph)
+	}
+	if err := copyToClipboard(out, cmd); err != nil {
+		return err
+	}
+	fprintln(out, "Copied to clipboard")
+	return nil
+}
+
+// copyToClipboard puts the text on the clipboard. If the output
+// is a terminal, uses the OSC 52 escape sequence. Also uses the first
+// available clipboard tool, since not all terminals support OSC 52.
+func copyToClipboard(out io.Writer, text string) error {
+	osc52 := isTerminal(out)
+	if osc52 {
+		copyOSC52(out, text)
+	}
+	err := copyTool(text)
+	if err != nil && !osc52 {
+		return err
+	}
+	return nil
+}
+
+// copyOSC52 puts the text on the clipboard using the OSC 52 terminal
+// escape sequence. Works over SSH and in tmux, as long as the terminal
+// supports it.
+func copyOSC52(out io.Writer, text string) {
+	seq := "\033]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		// Pass the sequence through tmux to the outer terminal.
+		seq = "\033Ptmux;\033" + seq + "\033\\"
+	}
+	_, _ = fmt.Fprint(out, seq)
+}
+
+// copyTool puts the text on the clipboard using the first
+// available clipboard tool.
+func copyTool(text string) error {
+	for _, tool := range clipboardTools {
+		if tool.env != "" && os.Getenv(tool.env) == "" {
+			continue
+		}
+		path, err := exec.LookPath(tool.name)
+		if err != nil {
+			continue
+		}
+		// Don't capture the output: xclip and xsel stay in the background
+		// to serve the clipboard, so reading their output would block.
+		cmd := exec.Command(path, tool.args...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("copy with %s: %w", tool.name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("clipboard is not available (install wl-copy, xclip or xsel)")
+}
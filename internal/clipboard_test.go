@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+// fakeClipboard installs a fake wl-copy tool and returns
+// the path of the file it writes the copied text to.
+func fakeClipboard(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "clipboard")
+	script := "#!/bin/sh\ncat > " + file + "\n"
+	err := os.WriteFile(filepath.Join(dir, "wl-copy"), []byte(script), 0755)
+	be.Err(t, err, nil)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+	return file
+}
+
+// readClipboard returns the text copied to the fake clipboard.
+func readClipboard(t *testing.T, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	be.Err(t, err, nil)
+	return string(data)
+}
+
+func Test_copyCommand(t *testing.T) {
+	t.Run("copy", func(t *testing.T) {
+		file := fakeClipboard(t)
+		history := &History{messages: conversation("list", "ls -l\n\nLists files.")}
+		out := &bytes.Buffer{}
+		err := copyCommand(out, history)
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "Copied to clipboard\n")
+		be.Equal(t, readClipboard(t, file), "ls -l")
+	})
+	t.Run("no command", func(t *testing.T) {
+		err := copyCommand(&bytes.Buffer{}, &History{})
+		be.Err(t, err, "no command to copy")
+	})
+	t.Run("redacted", func(t *testing.T) {
+		history := &History{messages: conversation("login", "login -p REDACTED_SECRET_1")}
+		err := copyCommand(&bytes.Buffer{}, history)
+		be.Err(t, err, "the command contains a redacted secret (REDACTED_SECRET_1), copy it from the answer")
+	})
+}
+
+func Test_copyTool(t *testing.T) {
+	t.Run("not available", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		err := copyTool("ls -l")
+		be.Err(t, err, "clipboard is not available")
+	})
+	t.Run("needs display", func(t *testing.T) {
+		fakeClipboard(t)
+		t.Setenv("WAYLAND_DISPLAY", "")
+		t.Setenv("DISPLAY", "")
+		err := copyTool("ls -l")
+		be.Err(t, err, "clipboard is not available")
+	})
+	t.Run("failed", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "wl-copy"), []byte("#!/bin/sh\nexit 1\n"), 0755)
+		be.Err(t, err, nil)
+		t.Setenv("PATH", dir)
+		t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+		err = copyTool("ls -l")
+		be.Err(t, err, "copy with wl-copy: exit status 1")
+	})
+}
+
+func Test_copyOSC52(t *testing.T) {
+	t.Run("terminal", func(t *testing.T) {
+		t.Setenv("TMUX", "")
+		out := &bytes.Buffer{}
+		copyOSC52(out, "ls -l")
+		be.Equal(t, out.String(), "\033]52;c;bHMgLWw=\a")
+	})
+	t.Run("tmux", func(t *testing.T) {
+		t.Setenv("TMUX", "/tmp/tmux-0/default,1,0")
+		out := &bytes.Buffer{}
+		copyOSC52(out, "ls -l")
+		be.True(t, strings.HasPrefix(out.String(), "\033Ptmux;\033\033]52;c;"))
+	})
+}
+
+func Test_copyEnabled(t *testing.T) {
+	t.Setenv("HOWTO_COPY", "")
+	be.Equal(t, copyEnabled(), false)
+	t.Setenv("HOWTO_COPY", "on")
+	be.Equal(t, copyEnabled(), true)
+}
@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// Maximum size of the piped input sent to the AI.
+const maxStdinSize = 64 * 1024
+
+// Maximum size of an attached file sent to the AI.
+const maxFileSize = 64 * 1024
+
+// Maximum size of a context block stored in the history.
+const maxStoredContext = 2 * 1024
+
+// How long to wait for the piped input to start before telling the user.
+// Without a terminal, the input may be an open pipe that nobody writes to
+// (e.g. with ssh), and howto should not hang on it, so it stops waiting.
+var stdinTimeout = time.Second
+
+// contextBlock is an additional context attached to the question,
+// such as piped input or a file.
+type contextBlock struct {
+	source  string
+	content string
+}
+
+// readContext reads the attached files and the piped input.
+// Prints the notes on waiting for the input to the given writer.
+func readContext(out io.Writer, in io.Reader, files []string) ([]contextBlock, error) {
+	var blocks []contextBlock
+	for _, path := range files {
+		block, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	stdin, err := readStdin(out, in)
+	if err != nil {
+		return nil, err
+	}
+	if stdin.content != "" {
+		blocks = append(blocks, stdin)
+	}
+
+	return blocks, nil
+}
+
+// readStdin reads the piped input up to the maximum size.
+// Returns an empty block if nothing is piped: the input is a terminal
+// or a device like /dev/null. If the pipe stays idle, waits for it
+// as long as it takes when the output is a terminal (the command
+// before howto is still working), and gives up with a warning otherwise.
+func readStdin(out io.Writer, in io.Reader) (contextBlock, error) {
+	if in == nil {
+		return contextBlock{}, nil
+	}
+	if f, ok := in.(*os.File); ok {
+		stat, err := f.Stat()
+		if err != nil {
+			return contextBlock{}, nil
+		}
+		switch mode := stat.Mode(); {
+		case mode.IsRegular():
+		case mode&(os.ModeNamedPipe|os.ModeSocket) != 0:
+			interactive := isTerminal(out)
+			in, err = waitInput(f, stdinTimeout, func() bool {
+				if interactive {
+					fprintln(out, italic("Waiting for the piped input (use --no-stdin to ignore it)..."))
+					return true
+				}
+				fprintln(out, "WARNING: no piped input after", stdinTimeout.String()+", ignoring it")
+				return false
+			})
+			if err != nil {
+				return contextBlock{}, fmt.Errorf("read stdin: %w", err)
+			}
+			if in == nil {
+				return contextBlock{}, nil
+			}
+		default:
+			return contextBlock{}, nil
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(in, maxStdinSize+1))
+	if err != nil {
+		return contextBlock{}, fmt.Errorf("read stdin: %w", err)
+	}
+	content := string(data)
+	if len(data) > maxStdinSize {
+		content = truncateBytes(content, maxStdinSize) + "\n... (truncated)"
+	}
+	return contextBlock{source: "stdin", content: content}, nil
+}
+
+// waitInput waits for the input to start. If there is no input
+// within the timeout, calls idle, which reports whether to keep waiting.
+// Returns a reader with the whole input, or nil if there is no input.
+func waitInput(in io.Reader, timeout time.Duration, idle func() bool) (io.Reader, error) {
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	first := make(chan chunk, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		n, err := in.Read(buf)
+		first <- chunk{buf[:n], err}
+	}()
+	// rest returns the whole input starting with the first chunk.
+	rest := func(c chunk) (io.Reader, error) {
+		if c.err == io.EOF {
+			return bytes.NewReader(c.data), nil
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		return io.MultiReader(bytes.NewReader(c.data), in), nil
+	}
+
+	select {
+	case c := <-first:
+		return rest(c)
+	case <-time.After(timeout):
+		if !idle() {
+			// Nobody writes to the pipe. The read is abandoned,
+			// howto exits soon anyway.
+			return nil, nil
+		}
+	}
+	return rest(<-first)
+}
+
+// isTerminal reports whether the reader or writer is an interactive terminal.
+func isTerminal(v any) bool {
+	f, ok := v.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// buildQuestion builds the user message from the question and
+// the context blocks. Each block is truncated to the given size
+// in bytes (0 means no limit).
+func buildQuestion(question string, blocks []contextBlock, limit int) string {
+	var b strings.Builder
+	b.WriteString(question)
+	for _, block := range blocks {
+		content := strings.TrimRight(block.content, "\n")
+		if limit > 0 && len(content) > limit {
+			content = truncateBytes(content, limit) + "\n... (truncated)"
+		}
+		b.WriteString("\n\n")
+		_, _ = fmt.Fprintf(&b, "<context source=%q>\n%s\n</context>", block.source, content)
+	}
+	return b.String()
+}
+
+// fitContext returns the size in bytes to truncate each context block to,
+// so that the question with the context fits into the AI token budget
+// (HOWTO_AI_MAX_TOKENS). Returns 0 if the blocks fit as they are.
+func fitContext(config ai.Config, question string, blocks []contextBlock) int {
+	size := ai.MaxExtraSize(config, question)
+	full := buildQuestion(question, blocks, 0)
+	if size < 0 || len(full)-len(question) <= size {
+		return 0
+	}
+
+	// Leave room for the context tags and the truncation marks.
+	sizes := make([]int, len(blocks))
+	for i, block := range blocks {
+		sizes[i] = len(strings.TrimRight(block.content, "\n"))
+		size -= len(fmt.Sprintf("\n\n<context source=%q>\n\n... (truncated)\n</context>", block.source))
+	}
+
+	// Small blocks don't need their whole share, so the rest
+	// goes to the larger ones.
+	slices.Sort(sizes)
+	n := len(sizes)
+	for _, s := range sizes {
+		if s > size/n {
+			break
+		}
+		size -= s
+		n--
+	}
+	return max(size/n, 1)
+}
+
+// contextRe matches a context block in the user message built with buildQuestion.
+var contextRe = regexp.MustCompile(`(?s)\n\n<context source=("(?:[^"\\]|\\.)*")>\n(.*?)\n</context>`)
+
+// parseQuestion splits the user message built with buildQuestion
+// into the question and the context blocks.
+func parseQuestion(content string) (string, []contextBlock) {
+	matches := contextRe.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return content, nil
+	}
+	question := content[:matches[0][0]]
+	var blocks []contextBlock
+	for _, m := range matches {
+		source, err := strconv.Unquote(content[m[2]:m[3]])
+		if err != nil {
+			source = content[m[2]:m[3]]
+		}
+		blocks = append(blocks, contextBlock{source: source, content: content[m[4]:m[5]]})
+	}
+	return question, blocks
+}
+
+// truncateBytes shortens the string to at most n bytes
+// without cutting a character in half.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// readFile reads the file to use as context. Files larger than
+// the maximum size are truncated, binary files are rejected.
+func readFile(path string) (contextBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return contextBlock{}, fmt.Errorf("read file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxFileSize+1))
+	if err != nil {
+		return contextBlock{}, fmt.Errorf("read file: %w", err)
+	}
+	if isBinary(data) {
+		return contextBlock{}, fmt.Errorf("read file: %s is not a text file", path)
+	}
+
+	content := string(data)
+	if len(data) > maxFileSize {
+		content = truncateBytes(content, maxFileSize) + "\n... (truncated)"
+	}
+	return contextBlock{source: path, content: content}, nil
+}
+
+// isBinary reports whether the data looks like a binary file:
+// has zero bytes or is not valid UTF-8 (checks the first 8 KB only).
+func isBinary(data []byte) bool {
+	const sniffSize = 8 * 1024
+	if len(data) > sniffSize {
+		// Do not cut a character in half, so that it's not counted as invalid.
+		n := sniffSize
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		data = data[:n]
+	}
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_readStdin(t *testing.T) {
+	t.Run("piped", func(t *testing.T) {
+		block, err := readStdin(&bytes.Buffer{}, strings.NewReader("total 0\n"))
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{source: "stdin", content: "total 0\n"})
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		block, err := readStdin(&bytes.Buffer{}, nil)
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{})
+	})
+
+	t.Run("terminal", func(t *testing.T) {
+		tty, err := os.Open("/dev/null")
+		if err != nil {
+			t.Skip("Skipping without /dev/null")
+		}
+		defer func() { _ = tty.Close() }()
+		block, err := readStdin(&bytes.Buffer{}, tty)
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{})
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		be.Err(t, err, nil)
+		defer func() { _ = r.Close() }()
+		go func() {
+			_, _ = w.WriteString("total 0\n")
+			_ = w.Close()
+		}()
+		block, err := readStdin(&bytes.Buffer{}, r)
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{source: "stdin", content: "total 0\n"})
+	})
+
+	t.Run("idle pipe", func(t *testing.T) {
+		defer func(timeout time.Duration) { stdinTimeout = timeout }(stdinTimeout)
+		stdinTimeout = 10 * time.Millisecond
+		r, w, err := os.Pipe()
+		be.Err(t, err, nil)
+		defer func() { _ = w.Close() }()
+		out := &bytes.Buffer{}
+		block, err := readStdin(out, r)
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{})
+		be.Equal(t, out.String(), "WARNING: no piped input after 10ms, ignoring it\n")
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "input.txt")
+		be.Err(t, os.WriteFile(path, []byte("hello\n"), 0600), nil)
+		f, err := os.Open(path)
+		be.Err(t, err, nil)
+		defer func() { _ = f.Close() }()
+		block, err := readStdin(&bytes.Buffer{}, f)
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{source: "stdin", content: "hello\n"})
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		data := strings.Repeat("a", maxStdinSize+100)
+		block, err := readStdin(&bytes.Buffer{}, strings.NewReader(data))
+		be.Err(t, err, nil)
+		be.True(t, strings.HasPrefix(block.content, strings.Repeat("a", maxStdinSize)))
+		be.True(t, strings.HasSuffix(block.content, "\n... (truncated)"))
+	})
+}
+
+func Test_readFile(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("text", func(t *testing.T) {
+		path := filepath.Join(dir, "compose.yml")
+		err := os.WriteFile(path, []byte("services:\n  db:\n"), 0600)
+		be.Err(t, err, nil)
+		block, err := readFile(path)
+		be.Err(t, err, nil)
+		be.Equal(t, block, contextBlock{source: path, content: "services:\n  db:\n"})
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		path := filepath.Join(dir, "large.csv")
+		err := os.WriteFile(path, []byte(strings.Repeat("a,b\n", maxFileSize)), 0600)
+		be.Err(t, err, nil)
+		block, err := readFile(path)
+		be.Err(t, err, nil)
+		be.True(t, len(block.content) < maxFileSize+100)
+		be.True(t, strings.HasSuffix(block.content, "\n... (truncated)"))
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		path := filepath.Join(dir, "image.png")
+		err := os.WriteFile(path, []byte("\x89PNG\r\n\x1a\n\x00\x00"), 0600)
+		be.Err(t, err, nil)
+		_, err = readFile(path)
+		be.Err(t, err, "is not a text file")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := readFile(filepath.Join(dir, "missing.txt"))
+		be.Err(t, err, os.ErrNotExist)
+	})
+}
+
+func Test_isBinary(t *testing.T) {
+	be.Equal(t, isBinary([]byte("hello")), false)
+	be.Equal(t, isBinary([]byte("привет")), false)
+	be.Equal(t, isBinary([]byte("a\x00b")), true)
+	be.Equal(t, isBinary([]byte("\xff\xfe")), true)
+	// A multi-byte character on the sniff boundary is not a sign of binary data.
+	be.Equal(t, isBinary([]byte(strings.Repeat("ж", 5000))), false)
+}
+
+func Test_waitInput(t *testing.T) {
+	t.Run("slow input", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		be.Err(t, err, nil)
+		defer func() { _ = r.Close() }()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = w.WriteString("error: oops\n")
+			_ = w.Close()
+		}()
+		var waited bool
+		in, err := waitInput(r, 10*time.Millisecond, func() bool {
+			waited = true
+			return true
+		})
+		be.Err(t, err, nil)
+		be.True(t, waited)
+		data, err := io.ReadAll(in)
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "error: oops\n")
+	})
+	t.Run("gave up", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		be.Err(t, err, nil)
+		defer func() { _ = w.Close() }()
+		in, err := waitInput(r, 10*time.Millisecond, func() bool { return false })
+		be.Err(t, err, nil)
+		be.True(t, in == nil)
+	})
+}
+
+func Test_readContext(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Makefile")
+	err := os.WriteFile(path, []byte("build:\n"), 0600)
+	be.Err(t, err, nil)
+
+	blocks, err := readContext(&bytes.Buffer{}, strings.NewReader("piped"), []string{path})
+	be.Err(t, err, nil)
+	want := []contextBlock{
+		{source: path, content: "build:\n"},
+		{source: "stdin", content: "piped"},
+	}
+	be.Equal(t, blocks, want)
+}
+
+func Test_buildQuestion(t *testing.T) {
+	t.Run("no context", func(t *testing.T) {
+		got := buildQuestion("why", nil, 0)
+		be.Equal(t, got, "why")
+	})
+
+	t.Run("with context", func(t *testing.T) {
+		blocks := []contextBlock{{source: "stdin", content: "error: oops\n"}}
+		got := buildQuestion("why", blocks, 0)
+		be.Equal(t, got, "why\n\n<context source=\"stdin\">\nerror: oops\n</context>")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		blocks := []contextBlock{{source: "stdin", content: "привет"}}
+		got := buildQuestion("why", blocks, 5)
+		be.Equal(t, got, "why\n\n<context source=\"stdin\">\nпр\n... (truncated)\n</context>")
+	})
+}
+
+func Test_fitContext(t *testing.T) {
+	config := ai.Config{Prompt: "prompt", MaxTokens: 1000}
+	large := contextBlock{source: "stdin", content: strings.Repeat("a", 20*1024)}
+	small := contextBlock{source: "Makefile", content: strings.Repeat("b", 100)}
+
+	t.Run("fits", func(t *testing.T) {
+		be.Equal(t, fitContext(config, "why", []contextBlock{small}), 0)
+	})
+	t.Run("no budget", func(t *testing.T) {
+		be.Equal(t, fitContext(ai.Config{}, "why", []contextBlock{large}), 0)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		blocks := []contextBlock{small, large}
+		limit := fitContext(config, "why", blocks)
+		got := buildQuestion("why", blocks, limit)
+		be.True(t, len(got)-len("why") <= ai.MaxExtraSize(config, "why"))
+		// The small block is sent as is.
+		_, parsed := parseQuestion(got)
+		be.Equal(t, parsed[0], small)
+		be.True(t, strings.HasSuffix(parsed[1].content, "... (truncated)"))
+	})
+}
+
+func Test_parseQuestion(t *testing.T) {
+	t.Run("no context", func(t *testing.T) {
+		question, blocks := parseQuestion("why")
+		be.Equal(t, question, "why")
+		be.Equal(t, len(blocks), 0)
+	})
+
+	t.Run("with context", func(t *testing.T) {
+		blocks := []contextBlock{
+			{source: "dir/my \"file\".txt", content: "line 1\nline 2"},
+			{source: "stdin", content: "error: oops"},
+		}
+		question, got := parseQuestion(buildQuestion("why", blocks, 0))
+		be.Equal(t, question, "why")
+		be.Equal(t, got, blocks)
+	})
+}
+
+func Test_truncateBytes(t *testing.T) {
+	be.Equal(t, truncateBytes("hello", 10), "hello")
+	be.Equal(t, truncateBytes("hello", 3), "hel")
+	be.Equal(t, truncateBytes("привет", 3), "п")
+}
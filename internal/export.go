@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// exportFile is the JSON export format.
+type exportFile struct {
+	Version  int       `json:"version"`
+	Session  string    `json:"session,omitempty"`
+	Messages []message `json:"messages"`
+}
+
+// export prints the conversation in the given format (md, json or sh).
+// The argument is the format, optionally followed by the session name
+// or the archive entry ID. Exports the current conversation by default.
+func export(out io.Writer, history *History, arg string) error {
+	format, source, _ := strings.Cut(arg, " ")
+	source = strings.TrimSpace(source)
+
+	name, messages, err := exportSource(history, source)
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return fmt.Errorf("nothing to export")
+	}
+
+	switch format {
+	case "md":
+		exportMarkdown(out, name, messages)
+	case "json":
+		return exportJSON(out, name, messages)
+	case "sh":
+		exportShell(out, name, messages, runCounts(history.dir))
+	default:
+		return fmt.Errorf("unknown export format: %s (use md, json or sh)", format)
+	}
+	return nil
+}
+
+// exportSource returns the name and the messages of the conversation
+// to export: the current one, the named session, or the archive entry
+// (if the source is a number).
+func exportSource(history *History, source string) (string, []message, error) {
+	if source == "" || source == history.sessionName() {
+		return history.sessionName(), history.messages, nil
+	}
+
+	if _, err := strconv.Atoi(source); err == nil {
+		entries, err := readArchive(history.dir)
+		if err != nil {
+			return "", nil, err
+		}
+		entry, err := findEntry(entries, source)
+		if err != nil {
+			return "", nil, err
+		}
+		messages := []message{
+			{Role: roleUser, Content: entry.question, Time: entry.time, Cwd: entry.cwd},
+			{Role: roleAssistant, Content: entry.answer, Time: entry.time, ID: entry.id},
+		}
+		return "#" + source, messages, nil
+	}
+
+	hist, err := loadExistingSession(history, source)
+	if err != nil {
+		return "", nil, err
+	}
+	return source, hist.messages, nil
+}
+
+// exportMarkdown prints the conversation as Markdown:
+// questions as headings, commands in fenced code blocks,
+// followed by the explanations.
+func exportMarkdown(out io.Writer, name string, messages []message) {
+	fprintln(out, "# Howto:", name)
+	for _, msg := range messages {
+		fprintln(out)
+		if msg.Role == roleUser {
+			question, blocks := parseQuestion(msg.Content)
+			fprintln(out, "## "+strings.ReplaceAll(question, "\n", " "))
+			for _, block := range blocks {
+				fprintln(out)
+				fprintln(out, "Context from `"+block.source+"`:")
+				fprintln(out)
+				fprintln(out, fence(block.content)+"text")
+				fprintln(out, block.content)
+				fprintln(out, fence(block.content))
+			}
+			continue
+		}
+		command, explanation, _ := strings.Cut(msg.Content, "\n")
+		fprintln(out, fence(command)+"sh")
+		fprintln(out, command)
+		fprintln(out, fence(command))
+		if explanation = strings.TrimSpace(explanation); explanation != "" {
+			fprintln(out)
+			fprintln(out, explanation)
+		}
+	}
+}
+
+// fence returns a code fence long enough
+// to enclose the text containing backticks.
+func fence(s string) string {
+	longest, n := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			n++
+			longest = max(longest, n)
+		} else {
+			n = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// exportJSON prints the conversation as JSON,
+// with all the message details.
+func exportJSON(out io.Writer, name string, messages []message) error {
+	file := exportFile{Version: historyVersion, Session: name, Messages: messages}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	fprintln(out, string(data))
+	return nil
+}
+
+// exportShell prints the suggested commands as a shell script.
+// The commands that were run (according to the archive) are kept,
+// the others are commented out. The questions and explanations
+// become comments.
+func exportShell(out io.Writer, name string, messages []message, runs map[int]int) {
+	fprintln(out, "#!/bin/sh")
+	fprintln(out, "# Exported from howto:", name)
+	for _, msg := range messages {
+		if msg.Role == roleUser {
+			question, _ := parseQuestion(msg.Content)
+			fprintln(out)
+			for _, line := range strings.Split(question, "\n") {
+				fprintln(out, "# Q:", line)
+			}
+			continue
+		}
+		command, explanation, _ := strings.Cut(msg.Content, "\n")
+		for _, line := range strings.Split(strings.TrimSpace(explanation), "\n") {
+			if line != "" {
+				fprintln(out, "#", line)
+			}
+		}
+		if msg.Run != nil || runs[msg.ID] > 0 {
+			fprintln(out, command)
+		} else {
+			fprintln(out, "# (not run)", command)
+		}
+	}
+}
+
+// runCounts returns the number of runs of each archive entry by ID.
+// Returns an empty map if the archive is not available.
+func runCounts(dir string) map[int]int {
+	counts := map[int]int{}
+	entries, err := readArchive(dir)
+	if err != nil {
+		return counts
+	}
+	for _, entry := range entries {
+		counts[entry.id] = entry.runs
+	}
+	return counts
+}
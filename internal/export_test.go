@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_exportMarkdown(t *testing.T) {
+	messages := []message{
+		{Role: roleUser, Content: buildQuestion("why it fails", []contextBlock{{source: "stdin", content: "error: oops"}}, 0)},
+		{Role: roleAssistant, Content: "ls -l\n\nLists `files`."},
+		{Role: roleUser, Content: "only ``code``"},
+		{Role: roleAssistant, Content: "echo ```"},
+	}
+	out := &bytes.Buffer{}
+	exportMarkdown(out, "default", messages)
+	want := "# Howto: default\n\n" +
+		"## why it fails\n\n" +
+		"Context from `stdin`:\n\n" +
+		"```text\nerror: oops\n```\n\n" +
+		"```sh\nls -l\n```\n\n" +
+		"Lists `files`.\n\n" +
+		"## only ``code``\n\n" +
+		"````sh\necho ```\n````\n"
+	be.Equal(t, out.String(), want)
+}
+
+func Test_exportJSON(t *testing.T) {
+	messages := []message{
+		{Role: roleUser, Content: "list files", Cwd: "/home"},
+		{Role: roleAssistant, Content: "ls -l", Model: "gpt-4o", ID: 3},
+	}
+	out := &bytes.Buffer{}
+	err := exportJSON(out, "default", messages)
+	be.Err(t, err, nil)
+
+	var got exportFile
+	err = json.Unmarshal(out.Bytes(), &got)
+	be.Err(t, err, nil)
+	be.Equal(t, got.Version, historyVersion)
+	be.Equal(t, got.Session, "default")
+	be.Equal(t, len(got.Messages), 2)
+	be.Equal(t, got.Messages[0].Cwd, "/home")
+	be.Equal(t, got.Messages[1].Model, "gpt-4o")
+	be.Equal(t, got.Messages[1].ID, 3)
+}
+
+func Test_exportShell(t *testing.T) {
+	messages := []message{
+		{Role: roleUser, Content: "list files"},
+		{Role: roleAssistant, Content: "ls -l\n\nLists files.\nIn long format.", ID: 1},
+		{Role: roleUser, Content: "remove them"},
+		{Role: roleAssistant, Content: "rm *", ID: 2},
+	}
+	out := &bytes.Buffer{}
+	exportShell(out, "cleanup", messages, map[int]int{1: 2})
+	want := "#!/bin/sh\n" +
+		"# Exported from howto: cleanup\n\n" +
+		"# Q: list files\n" +
+		"# Lists files.\n" +
+		"# In long format.\n" +
+		"ls -l\n\n" +
+		"# Q: remove them\n" +
+		"# (not run) rm *\n"
+	be.Equal(t, out.String(), want)
+}
+
+func Test_export(t *testing.T) {
+	dir := t.TempDir()
+	history, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+	history.messages = conversation("list files", "ls -l")
+
+	t.Run("current", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		err := export(out, history, "sh")
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "#!/bin/sh\n# Exported from howto: default\n\n# Q: list files\n# (not run) ls -l\n")
+	})
+
+	t.Run("session", func(t *testing.T) {
+		hist, err := loadSession(dir, "", "deploy")
+		be.Err(t, err, nil)
+		hist.messages = conversation("restart", "systemctl restart app")
+		be.Err(t, hist.Save(), nil)
+
+		out := &bytes.Buffer{}
+		err = export(out, history, "md deploy")
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "# Howto: deploy\n\n## restart\n\n```sh\nsystemctl restart app\n```\n")
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		id, err := archiveAnswer(dir, newQuestion("free space"), newAnswer("df -h"))
+		be.Err(t, err, nil)
+		be.Err(t, archiveRun(dir, id, 0), nil)
+
+		out := &bytes.Buffer{}
+		err = export(out, history, "sh 1")
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "#!/bin/sh\n# Exported from howto: #1\n\n# Q: free space\ndf -h\n")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		be.Err(t, export(out, history, "pdf"), "unknown export format: pdf (use md, json or sh)")
+		be.Err(t, export(out, history, "md missing"), "session not found: missing")
+		be.Err(t, export(out, history, "md 42"), "entry not found: 42")
+		be.Err(t, export(out, &History{}, "md"), "nothing to export")
+	})
+}
@@ -0,0 +1,271 @@
+package internal
+
+import "strings"
+
+// tokenKind is a kind of shell command token.
+type tokenKind int
+
+const (
+	tokSpace    tokenKind = iota // whitespace
+	tokWord                      // argument
+	tokProgram                   // program name
+	tokFlag                      // -f, --flag, --flag=value
+	tokString                    // 'single' or "double" quoted string
+	tokVariable                  // $VAR, ${VAR}, VAR=value
+	tokRedirect                  // |, >, >>, <, 2>&1
+	tokOperator                  // &&, ||, ;, &, (, ), $(
+	tokComment                   // # comment
+)
+
+// token is a single shell command token.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// theme maps token kinds to terminal colors. Uses only the basic
+// colors, so the actual shades come from the terminal palette
+// and stay readable on both light and dark backgrounds.
+var theme = map[tokenKind]string{
+	tokProgram:  "\033[1m",    // bold
+	tokFlag:     "\033[36m",   // cyan
+	tokString:   "\033[32m",   // green
+	tokVariable: "\033[35m",   // magenta
+	tokRedirect: "\033[34m",   // blue
+	tokOperator: "\033[1;34m", // bold blue
+	tokComment:  "\033[2m",    // dim
+}
+
+// wrappers are programs that run another program
+// specified as their argument.
+var wrappers = map[string]bool{
+	"command": true, "doas": true, "env": true, "exec": true, "nice": true,
+	"nohup": true, "sudo": true, "time": true, "watch": true, "xargs": true,
+}
+
+// highlight colorizes the shell command for terminal output.
+// Returns the command as is if colors are disabled.
+func highlight(cmd string) string {
+	if !color {
+		return cmd
+	}
+	var b strings.Builder
+	for _, tok := range tokenize(cmd) {
+		style, ok := theme[tok.kind]
+		if !ok {
+			b.WriteString(tok.text)
+			continue
+		}
+		b.WriteString(style)
+		b.WriteString(tok.text)
+		b.WriteString("\033[0m")
+	}
+	return b.String()
+}
+
+// tokenize splits the shell command into tokens.
+// Concatenating the token texts gives the original command.
+func tokenize(cmd string) []token {
+	var tokens []token
+	expectProgram := true
+	for i := 0; i < len(cmd); {
+		c := cmd[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			n := i + 1
+			for n < len(cmd) && (cmd[n] == ' ' || cmd[n] == '\t' || cmd[n] == '\n') {
+				n++
+			}
+			tokens = append(tokens, token{tokSpace, cmd[i:n]})
+			i = n
+
+		case c == '#':
+			tokens = append(tokens, token{tokComment, cmd[i:]})
+			i = len(cmd)
+
+		case c == '\'' || c == '"':
+			n := skipQuoted(cmd, i)
+			tokens = append(tokens, token{tokString, cmd[i:n]})
+			i = n
+			expectProgram = false
+
+		case c == '$' && i+1 < len(cmd) && cmd[i+1] == '(':
+			tokens = append(tokens, token{tokOperator, "$("})
+			i += 2
+			expectProgram = true
+
+		case c == '$':
+			n := skipVariable(cmd, i)
+			tokens = append(tokens, token{tokVariable, cmd[i:n]})
+			i = n
+			expectProgram = false
+
+		case isOperatorStart(c) || isRedirectStart(cmd, i):
+			tok := readOperator(cmd, i)
+			tokens = append(tokens, tok)
+			i += len(tok.text)
+			if tok.kind == tokOperator || tok.text == "|" || tok.text == "|&" {
+				expectProgram = tok.text != ")"
+			}
+
+		default:
+			n := skipWord(cmd, i)
+			word := cmd[i:n]
+			kind := classifyWord(word, expectProgram)
+			tokens = append(tokens, token{kind, word})
+			i = n
+			switch kind {
+			case tokProgram:
+				expectProgram = wrappers[word]
+			case tokVariable:
+				// Leading assignments, e.g. LANG=C sort.
+			case tokFlag:
+				// Wrappers can have flags, e.g. sudo -E cmd.
+			default:
+				expectProgram = false
+			}
+		}
+	}
+	return tokens
+}
+
+// classifyWord returns the kind of the unquoted word.
+func classifyWord(word string, expectProgram bool) tokenKind {
+	switch {
+	case strings.HasPrefix(word, "-") && len(word) > 1:
+		return tokFlag
+	case expectProgram && isAssignment(word):
+		return tokVariable
+	case expectProgram:
+		return tokProgram
+	default:
+		return tokWord
+	}
+}
+
+// isAssignment reports whether the word is a variable assignment (VAR=value).
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, c := range name {
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && (i == 0 || !isDigit) {
+			return false
+		}
+	}
+	return true
+}
+
+// isOperatorStart reports whether the character starts a control operator
+// or a redirection.
+func isOperatorStart(c byte) bool {
+	return strings.IndexByte("|&;<>()`", c) >= 0
+}
+
+// isRedirectStart reports whether a file descriptor redirection
+// (e.g. 2>file or 2>&1) starts at the given position.
+func isRedirectStart(cmd string, i int) bool {
+	if cmd[i] < '0' || cmd[i] > '9' {
+		return false
+	}
+	if i > 0 && !strings.ContainsRune(" \t\n|&;", rune(cmd[i-1])) {
+		return false
+	}
+	return i+1 < len(cmd) && (cmd[i+1] == '>' || cmd[i+1] == '<')
+}
+
+// readOperator reads a control operator or a redirection at the given position.
+func readOperator(cmd string, i int) token {
+	rest := cmd[i:]
+	for _, op := range []string{"&&", "||", ";;", "$(", ";", "(", ")", "`"} {
+		if strings.HasPrefix(rest, op) {
+			return token{tokOperator, op}
+		}
+	}
+	redirects := []string{"&>>", "&>", "|&", "<<<", ">>", "<<", ">&", "<&", ">", "<", "|", "&"}
+	prefix := ""
+	if rest[0] >= '0' && rest[0] <= '9' {
+		prefix, rest = rest[:1], rest[1:]
+	}
+	for _, op := range redirects {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		if op == "&" && prefix == "" {
+			return token{tokOperator, op}
+		}
+		if (op == ">&" || op == "<&") && len(rest) > 2 && strings.IndexByte("0123456789-", rest[2]) >= 0 {
+			// Descriptor duplication, e.g. 2>&1 or >&-.
+			op = rest[:3]
+		}
+		return token{tokRedirect, prefix + op}
+	}
+	return token{tokWord, cmd[i : i+1]}
+}
+
+// skipQuoted returns the position right after the quoted string
+// starting at the given position.
+func skipQuoted(cmd string, i int) int {
+	quote := cmd[i]
+	for n := i + 1; n < len(cmd); n++ {
+		if quote == '"' && cmd[n] == '\\' {
+			n++
+			continue
+		}
+		if cmd[n] == quote {
+			return n + 1
+		}
+	}
+	return len(cmd)
+}
+
+// skipVariable returns the position right after the variable reference
+// ($VAR, ${VAR}, $1, $?) starting at the given position.
+func skipVariable(cmd string, i int) int {
+	n := i + 1
+	if n >= len(cmd) {
+		return n
+	}
+	if cmd[n] == '{' {
+		end := strings.IndexByte(cmd[n:], '}')
+		if end < 0 {
+			return len(cmd)
+		}
+		return n + end + 1
+	}
+	if strings.IndexByte("?!#$@*-0123456789", cmd[n]) >= 0 {
+		return n + 1
+	}
+	for n < len(cmd) {
+		c := cmd[n]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// skipWord returns the position right after the word starting
+// at the given position. Quoted parts are considered part of the word,
+// as in --format='{{.Names}}'.
+func skipWord(cmd string, i int) int {
+	n := i
+	for n < len(cmd) {
+		c := cmd[n]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || isOperatorStart(c):
+			return n
+		case c == '\\':
+			n += 2
+		case (c == '\'' || c == '"') && n > i:
+			n = skipQuoted(cmd, n)
+		default:
+			n++
+		}
+	}
+	return len(cmd)
+}
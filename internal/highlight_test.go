@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_highlight(t *testing.T) {
+	t.Run("color", func(t *testing.T) {
+		defer setColor(true)()
+		got := highlight("ls -la | grep $HOME")
+		want := "\033[1mls\033[0m \033[36m-la\033[0m \033[34m|\033[0m " +
+			"\033[1mgrep\033[0m \033[35m$HOME\033[0m"
+		be.Equal(t, got, want)
+	})
+	t.Run("no color", func(t *testing.T) {
+		defer setColor(false)()
+		got := highlight("ls -la | grep $HOME")
+		be.Equal(t, got, "ls -la | grep $HOME")
+	})
+}
+
+func Test_tokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want []token
+	}{
+		{
+			name: "program with flags",
+			cmd:  "curl -I --location example.org",
+			want: []token{
+				{tokProgram, "curl"}, {tokSpace, " "}, {tokFlag, "-I"}, {tokSpace, " "},
+				{tokFlag, "--location"}, {tokSpace, " "}, {tokWord, "example.org"},
+			},
+		},
+		{
+			name: "pipeline",
+			cmd:  "du -sh * | sort -h",
+			want: []token{
+				{tokProgram, "du"}, {tokSpace, " "}, {tokFlag, "-sh"}, {tokSpace, " "},
+				{tokWord, "*"}, {tokSpace, " "}, {tokRedirect, "|"}, {tokSpace, " "},
+				{tokProgram, "sort"}, {tokSpace, " "}, {tokFlag, "-h"},
+			},
+		},
+		{
+			name: "strings and variables",
+			cmd:  `echo "hello $USER" 'bye' ${HOME}`,
+			want: []token{
+				{tokProgram, "echo"}, {tokSpace, " "}, {tokString, `"hello $USER"`}, {tokSpace, " "},
+				{tokString, "'bye'"}, {tokSpace, " "}, {tokVariable, "${HOME}"},
+			},
+		},
+		{
+			name: "redirections and operators",
+			cmd:  "make >build.log 2>&1 && echo ok; cat <in",
+			want: []token{
+				{tokProgram, "make"}, {tokSpace, " "}, {tokRedirect, ">"}, {tokWord, "build.log"},
+				{tokSpace, " "}, {tokRedirect, "2>&1"}, {tokSpace, " "}, {tokOperator, "&&"},
+				{tokSpace, " "}, {tokProgram, "echo"}, {tokSpace, " "}, {tokWord, "ok"},
+				{tokOperator, ";"}, {tokSpace, " "}, {tokProgram, "cat"}, {tokSpace, " "},
+				{tokRedirect, "<"}, {tokWord, "in"},
+			},
+		},
+		{
+			name: "wrappers and assignments",
+			cmd:  "LANG=C sudo -E sort file",
+			want: []token{
+				{tokVariable, "LANG=C"}, {tokSpace, " "}, {tokProgram, "sudo"}, {tokSpace, " "},
+				{tokFlag, "-E"}, {tokSpace, " "}, {tokProgram, "sort"}, {tokSpace, " "},
+				{tokWord, "file"},
+			},
+		},
+		{
+			name: "command substitution",
+			cmd:  "kill $(pgrep node)",
+			want: []token{
+				{tokProgram, "kill"}, {tokSpace, " "}, {tokOperator, "$("}, {tokProgram, "pgrep"},
+				{tokSpace, " "}, {tokWord, "node"}, {tokOperator, ")"},
+			},
+		},
+		{
+			name: "quoted flag value",
+			cmd:  "docker ps --format='{{.Names}} {{.Status}}'",
+			want: []token{
+				{tokProgram, "docker"}, {tokSpace, " "}, {tokWord, "ps"}, {tokSpace, " "},
+				{tokFlag, "--format='{{.Names}} {{.Status}}'"},
+			},
+		},
+		{
+			name: "comment",
+			cmd:  "ls # list files",
+			want: []token{
+				{tokProgram, "ls"}, {tokSpace, " "}, {tokComment, "# list files"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tokenize(tt.cmd)
+			be.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func Test_tokenize_lossless(t *testing.T) {
+	cmds := []string{
+		`find . -name "*.log" -mtime +7 -delete`,
+		`for f in *.jpg; do convert "$f" "${f%.jpg}.png"; done`,
+		"echo `date` &>/dev/null &",
+		`unterminated "string`,
+		`trailing \`,
+	}
+	for _, cmd := range cmds {
+		var b strings.Builder
+		for _, tok := range tokenize(cmd) {
+			b.WriteString(tok.text)
+		}
+		be.Equal(t, b.String(), cmd)
+	}
+}
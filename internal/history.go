@@ -1,32 +1,159 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/nalgeon/howto/internal/ai"
 )
 
 // Name of the file containing the history.
 const fileName = "howto-history.json"
 
+// Maximum size of the history file in bytes.
+// The oldest messages are dropped to keep the file within the limit.
+const maxHistorySize = 256 * 1024
+
+// Version of the history file format.
+// Version 0 is a plain array of strings (alternating questions and answers).
+const historyVersion = 1
+
+// Message roles.
+const (
+	roleUser      = "user"
+	roleAssistant = "assistant"
+)
+
+// historyFile is the history file format.
+type historyFile struct {
+	Version  int       `json:"version"`
+	Messages []message `json:"messages"`
+}
+
+// message is a single message in the conversation history.
+type message struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+	// AI vendor and model that produced the answer.
+	Vendor string `json:"vendor,omitempty"`
+	Model  string `json:"model,omitempty"`
+	// Working directory where the question was asked.
+	Cwd string `json:"cwd,omitempty"`
+	// ID of the answer in the archive.
+	ID int `json:"id,omitempty"`
+	// Result of running the suggested command.
+	Run *runResult `json:"run,omitempty"`
+}
+
+// runResult describes the result of running the suggested command.
+type runResult struct {
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	// Whether the user edited the suggested command.
+	Edited bool `json:"edited,omitempty"`
+	// Why the command failed to run or finish
+	// (e.g. it timed out), if not because of the command itself.
+	Error string `json:"error,omitempty"`
+}
+
+// Maximum size of the command output (stdout and stderr,
+// each) stored in the history.
+const maxRunOutput = 4096
+
+// describe describes the run to the AI as a user message.
+func (r *runResult) describe() string {
+	var b strings.Builder
+	verb := "ran"
+	if r.Edited {
+		verb = "edited the command and ran"
+	}
+	fmt.Fprintf(&b, "I %s `%s`: exit code %d, took %s.",
+		verb, r.Command, r.ExitCode, r.Duration.Round(time.Millisecond))
+	if r.Error != "" {
+		fmt.Fprintf(&b, " Error: %s.", r.Error)
+	}
+	if r.Stdout == "" && r.Stderr == "" {
+		b.WriteString(" There was no output.")
+		return b.String()
+	}
+	if r.Stdout != "" {
+		f := fence(r.Stdout)
+		fmt.Fprintf(&b, "\nThe output:\n%s\n%s\n%s", f, r.Stdout, f)
+	}
+	if r.Stderr != "" {
+		f := fence(r.Stderr)
+		fmt.Fprintf(&b, "\nThe error output:\n%s\n%s\n%s", f, r.Stderr, f)
+	}
+	return b.String()
+}
+
+// newQuestion creates a message with the user question.
+func newQuestion(content string) message {
+	cwd, _ := os.Getwd()
+	return message{Role: roleUser, Content: content, Time: time.Now(), Cwd: cwd}
+}
+
+// newAnswer creates a message with the assistant answer.
+func newAnswer(content string) message {
+	return message{
+		Role:    roleAssistant,
+		Content: content,
+		Time:    time.Now(),
+		Vendor:  ai.Conf.Vendor,
+		Model:   ai.Conf.Model,
+	}
+}
+
 // History represents the conversation history
 // between the user and the assistant.
 type History struct {
+	// Configuration directory, empty for a transient history.
+	dir string
+	// Terminal key, empty for the global history.
+	terminal string
+	// Session name, empty for the default session.
+	session  string
 	path     string
-	messages []string
+	messages []message
+	// Messages as they were when the history was loaded or saved.
+	base []message
+	// Whether the conversation was cleared since it was loaded.
+	cleared bool
+	// Problem encountered while loading the history.
+	warning string
 }
 
-// LoadHistory loads the conversation history from the file system.
+// LoadHistory loads the conversation history of the current session
+// in the current terminal from the file system.
 func LoadHistory() (*History, error) {
-	path, err := getHistoryPath()
+	dir, err := getConfigDir()
 	if err != nil {
 		return nil, fmt.Errorf("load history: %w", err)
 	}
-	hist, err := loadHistory(path)
+	cleanupTerminals(dir, time.Now())
+
+	terminal := terminalKey()
+	session := currentSession(dir, terminal)
+	if session != defaultSession {
+		// Keep the current session from being cleaned up.
+		now := time.Now()
+		_ = os.Chtimes(currentSessionPath(dir, terminal), now, now)
+	}
+
+	hist, err := loadSession(dir, terminal, session)
 	if err != nil {
 		return nil, fmt.Errorf("load history: %w", err)
 	}
@@ -34,41 +161,166 @@ func LoadHistory() (*History, error) {
 }
 
 // Save saves the conversation history to the file system.
+// Merges the changes made by other howto processes since the history
+// was loaded, and replaces the file atomically while holding the lock,
+// so that concurrent processes never corrupt or overwrite it.
 func (h *History) Save() error {
 	if h.path == "" {
 		// Transient history, no need to save.
 		return nil
 	}
-	data, err := json.Marshal(h.messages)
+	err := withLock(h.path, func() error {
+		h.merge()
+		var data []byte
+		for {
+			file := historyFile{Version: historyVersion, Messages: h.messages}
+			var err error
+			data, err = json.Marshal(file)
+			if err != nil {
+				return err
+			}
+			if len(data) <= maxHistorySize || len(h.messages) <= 1 {
+				break
+			}
+			h.dropOldest()
+		}
+		if err := writeFileAtomic(h.path, data); err != nil {
+			return err
+		}
+		h.base = slices.Clone(h.messages)
+		h.cleared = false
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("save history: %w", err)
 	}
-	err = os.WriteFile(h.path, data, 0600)
+	return nil
+}
+
+// merge adds the messages saved by other howto processes since
+// the history was loaded. Only merges if both this process and the others
+// have appended to the same conversation; if either has cleared
+// or rewritten it (e.g. with a new question or -undo), this process's
+// version wins. Must be called while holding the lock.
+func (h *History) merge() {
+	data, err := os.ReadFile(h.path)
 	if err != nil {
-		return fmt.Errorf("save history: %w", err)
+		return
 	}
-	return nil
+	saved, err := parseHistory(data)
+	if err != nil || sameMessages(saved, h.base) {
+		return
+	}
+	if h.cleared || !hasPrefix(h.messages, h.base) || !hasPrefix(saved, h.base) {
+		return
+	}
+
+	merged := append(saved, h.messages[len(h.base):]...)
+	// Keep the run results recorded since the load.
+	for i, msg := range h.messages[:len(h.base)] {
+		if msg.Run == nil || h.base[i].Run != nil {
+			continue
+		}
+		for j := range merged {
+			if sameMessage(merged[j], msg) {
+				merged[j].Run = msg.Run
+			}
+		}
+	}
+	h.messages = merged
+}
+
+// hasPrefix reports whether the messages start with the prefix,
+// ignoring the run results.
+func hasPrefix(messages, prefix []message) bool {
+	return len(messages) >= len(prefix) && sameMessages(messages[:len(prefix)], prefix)
+}
+
+// sameMessages reports whether the message lists are the same,
+// ignoring the run results.
+func sameMessages(a, b []message) bool {
+	return slices.EqualFunc(a, b, sameMessage)
+}
+
+// sameMessage reports whether the messages are the same,
+// ignoring the run results.
+func sameMessage(a, b message) bool {
+	return a.Role == b.Role && a.Content == b.Content &&
+		a.Time.Equal(b.Time) && a.ID == b.ID
 }
 
 // Add adds a message to the conversation history.
-func (h *History) Add(message string) {
-	h.messages = append(h.messages, message)
+func (h *History) Add(msg message) {
+	h.messages = append(h.messages, msg)
+}
+
+// dropOldest removes the oldest question along with its answer.
+func (h *History) dropOldest() {
+	n := 1
+	for n < len(h.messages) && h.messages[n].Role != roleUser {
+		n++
+	}
+	h.messages = h.messages[n:]
 }
 
 // Clear clears the conversation history.
 func (h *History) Clear() {
-	h.messages = []string{}
+	h.messages = []message{}
+	h.cleared = true
 }
 
 // LastCommand returns the last command from the conversation history.
-// By design, the last command is always the first line of the last message
-// (which is an answer from the assistant).
+// By design, the last command is always the first line
+// of the last answer from the assistant.
 func (h *History) LastCommand() string {
-	if len(h.messages) == 0 {
+	msg, ok := h.lastAnswer()
+	if !ok {
 		return ""
 	}
-	lastMessage := h.messages[len(h.messages)-1]
-	return strings.Split(lastMessage, "\n")[0]
+	return strings.Split(msg.Content, "\n")[0]
+}
+
+// lastAnswer returns the last answer from the assistant.
+func (h *History) lastAnswer() (message, bool) {
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		if h.messages[i].Role == roleAssistant {
+			return h.messages[i], true
+		}
+	}
+	return message{}, false
+}
+
+// recordRun stores the run result in the last answer,
+// so that the follow-up questions can use it.
+func (h *History) recordRun(result runResult) {
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		if h.messages[i].Role == roleAssistant {
+			h.messages[i].Run = &result
+			return
+		}
+	}
+}
+
+// chat returns the conversation history as a sequence
+// of messages to send to the AI. The results of the commands
+// the user ran follow the answers as user messages.
+func (h *History) chat() []ai.Message {
+	messages := make([]ai.Message, 0, len(h.messages))
+	for _, msg := range h.messages {
+		messages = append(messages, ai.Message{Role: msg.Role, Content: msg.Content})
+		if msg.Run != nil {
+			messages = append(messages, ai.Message{Role: roleUser, Content: msg.Run.describe()})
+		}
+	}
+	return messages
+}
+
+// sessionName returns the name of the history session.
+func (h *History) sessionName() string {
+	if h.session == "" {
+		return defaultSession
+	}
+	return h.session
 }
 
 // Print prints the conversation history to stdout.
@@ -77,25 +329,36 @@ func (h *History) Print(out io.Writer) {
 		_, _ = fmt.Fprintln(out, "(empty)")
 		return
 	}
-	for i, message := range h.messages {
+	width := terminalWidth()
+	for _, msg := range h.messages {
 		var prefix string
-		if i%2 == 0 {
+		if msg.Role == roleUser {
 			prefix = "🧑 "
+		} else if msg.Run != nil {
+			prefix = fmt.Sprintf("🤖 (ran, exit %d) ", msg.Run.ExitCode)
 		} else {
 			prefix = "🤖 "
 		}
-		message = prefix + strings.ReplaceAll(message, "\n", " ")
-		if len(message) > 80 {
-			message = message[:77] + "..."
-		}
-		_, _ = fmt.Fprintln(out, message)
+		line := prefix + strings.ReplaceAll(msg.Content, "\n", " ")
+		line = truncate(line, width)
+		_, _ = fmt.Fprintln(out, line)
 	}
 }
 
-// getHistoryPath returns the path to the history file.
+// getHistoryPath returns the path to the history file
+// of the default session.
+func getHistoryPath() (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, fileName), nil
+}
+
+// getConfigDir returns the path to the configuration directory.
 // Uses the OS-specific configuration directory
 // with a fallback to the home directory.
-func getHistoryPath() (string, error) {
+func getConfigDir() (string, error) {
 	var configDir string
 
 	switch runtime.GOOS {
@@ -122,25 +385,93 @@ func getHistoryPath() (string, error) {
 		}
 	}
 
-	return filepath.Join(configDir, fileName), nil
+	return configDir, nil
 }
 
 // loadHistory loads the conversation history from the specified file.
+// The lock is held only while reading, not until the history is saved,
+// so that a slow AI request or a long-running command does not block
+// other howto processes (Save merges their changes instead).
+// If the file is corrupt, backs it up and starts with an empty history.
 func loadHistory(path string) (*History, error) {
-	data, err := os.ReadFile(path)
-	if os.IsNotExist(err) {
-		return &History{path: path}, nil
+	var hist *History
+	err := withLock(path, func() error {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			hist = &History{path: path}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		messages, err := parseHistory(data)
+		if errors.Is(err, errHistoryVersion) {
+			return err
+		}
+		if err != nil {
+			hist, err = recoverHistory(path, err)
+			return err
+		}
+
+		hist = &History{path: path, messages: messages, base: slices.Clone(messages)}
+		return nil
+	})
+	return hist, err
+}
+
+// errHistoryVersion is returned when the history file
+// was written by a newer version of howto.
+var errHistoryVersion = errors.New("unsupported history version")
+
+// parseHistory parses the history file contents.
+// Supports the current format and migrates the legacy one
+// (a plain array of strings).
+func parseHistory(data []byte) ([]message, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var contents []string
+		err := json.Unmarshal(data, &contents)
+		if err != nil {
+			return nil, err
+		}
+		return migrateHistory(contents), nil
 	}
+
+	var file historyFile
+	err := json.Unmarshal(data, &file)
 	if err != nil {
 		return nil, err
 	}
+	if file.Version > historyVersion {
+		return nil, fmt.Errorf("%w: %d", errHistoryVersion, file.Version)
+	}
+	return file.Messages, nil
+}
 
-	var messages []string
-	err = json.Unmarshal(data, &messages)
-	if err != nil {
-		return nil, err
+// migrateHistory converts the legacy history (alternating questions
+// and answers) to messages.
+func migrateHistory(contents []string) []message {
+	messages := make([]message, len(contents))
+	for i, content := range contents {
+		role := roleUser
+		if i%2 == 1 {
+			role = roleAssistant
+		}
+		messages[i] = message{Role: role, Content: content}
 	}
+	return messages
+}
 
-	hist := &History{path: path, messages: messages}
-	return hist, nil
+// recoverHistory backs up the corrupt history file
+// and returns an empty history instead.
+func recoverHistory(path string, cause error) (*History, error) {
+	// Don't overwrite the earlier backups.
+	backup := path + "." + time.Now().Format("20060102-150405.000") + ".bak"
+	err := os.Rename(path, backup)
+	if err != nil {
+		return nil, fmt.Errorf("%w (backup failed: %w)", cause, err)
+	}
+	warning := fmt.Sprintf("history file is corrupt (%v), moved to %s", cause, backup)
+	return &History{path: path, warning: warning}, nil
 }
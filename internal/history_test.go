@@ -2,24 +2,33 @@ package internal
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
 )
 
 func TestHistory_Add(t *testing.T) {
 	h := &History{}
-	h.Add("test message")
-	be.Equal(t, len(h.messages), 1)
-	be.Equal(t, h.messages[0], "test message")
+	h.Add(newQuestion("test question"))
+	h.Add(newAnswer("test answer"))
+	be.Equal(t, len(h.messages), 2)
+	be.Equal(t, h.messages[0].Role, roleUser)
+	be.Equal(t, h.messages[0].Content, "test question")
+	be.True(t, h.messages[0].Cwd != "")
+	be.Equal(t, h.messages[1].Role, roleAssistant)
+	be.Equal(t, h.messages[1].Content, "test answer")
+	be.True(t, !h.messages[1].Time.IsZero())
 }
 
 func TestHistory_Clear(t *testing.T) {
-	h := &History{messages: []string{"test"}}
+	h := &History{messages: conversation("test")}
 	h.Clear()
 	be.Equal(t, len(h.messages), 0)
 }
@@ -27,29 +36,39 @@ func TestHistory_Clear(t *testing.T) {
 func TestHistory_LastCommand(t *testing.T) {
 	tests := []struct {
 		name     string
-		messages []string
+		messages []message
 		want     string
 	}{
 		{
 			name:     "empty history",
-			messages: []string{},
+			messages: conversation(),
 			want:     "",
 		},
 		{
-			name:     "single message",
-			messages: []string{"command\nexplanation"},
+			name:     "single answer",
+			messages: conversation("question", "command\nexplanation"),
 			want:     "command",
 		},
 		{
 			name:     "multiple messages",
-			messages: []string{"q1", "a1\ncmd1\nexp1", "q2", "a2\ncmd2\nexp2"},
+			messages: conversation("q1", "a1\ncmd1\nexp1", "q2", "a2\ncmd2\nexp2"),
 			want:     "a2",
 		},
 		{
 			name:     "no newline",
-			messages: []string{"command"},
+			messages: conversation("question", "command"),
 			want:     "command",
 		},
+		{
+			name:     "unanswered question",
+			messages: conversation("q1", "a1\nexp1", "q2"),
+			want:     "a1",
+		},
+		{
+			name:     "question only",
+			messages: conversation("question"),
+			want:     "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -62,36 +81,54 @@ func TestHistory_LastCommand(t *testing.T) {
 }
 
 func TestHistory_Print(t *testing.T) {
+	if getTermWidth(os.Stdout) > 0 {
+		t.Skip("Skipping on a real terminal")
+	}
+	t.Setenv("COLUMNS", "")
+
 	tests := []struct {
 		name     string
-		messages []string
+		messages []message
 		want     string
 	}{
 		{
 			name:     "empty history",
-			messages: []string{},
+			messages: conversation(),
 			want:     "(empty)\n",
 		},
 		{
 			name:     "single user message",
-			messages: []string{"hello"},
+			messages: conversation("hello"),
 			want:     "🧑 hello\n",
 		},
 		{
 			name:     "single assistant message",
-			messages: []string{"hello", "hi"},
+			messages: conversation("hello", "hi"),
 			want:     "🧑 hello\n🤖 hi\n",
 		},
 		{
 			name:     "long message",
-			messages: []string{"this is a very very very very very very very long message that should be truncated"},
-			want:     "🧑 this is a very very very very very very very long message that should be...\n",
+			messages: conversation("this is a very very very very very very very long message that should be truncated"),
+			want:     "🧑 this is a very very very very very very very long message that should be t...\n",
+		},
+		{
+			name:     "long unicode message",
+			messages: conversation("это очень очень очень очень очень очень очень длинное сообщение, которое надо обрезать"),
+			want:     "🧑 это очень очень очень очень очень очень очень длинное сообщение, которое н...\n",
 		},
 		{
 			name:     "message with newline",
-			messages: []string{"hello\nworld"},
+			messages: conversation("hello\nworld"),
 			want:     "🧑 hello world\n",
 		},
+		{
+			name: "run answer",
+			messages: []message{
+				{Role: roleUser, Content: "hello"},
+				{Role: roleAssistant, Content: "echo hi", Run: &runResult{Command: "echo hi", ExitCode: 1}},
+			},
+			want: "🧑 hello\n🤖 (ran, exit 1) echo hi\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -102,6 +139,63 @@ func TestHistory_Print(t *testing.T) {
 			be.Equal(t, out.String(), tt.want)
 		})
 	}
+
+	t.Run("terminal width", func(t *testing.T) {
+		t.Setenv("COLUMNS", "20")
+		h := &History{messages: conversation("this message is truncated to the terminal width")}
+		out := &bytes.Buffer{}
+		h.Print(out)
+		be.Equal(t, out.String(), "🧑 this message i...\n")
+	})
+}
+
+func TestHistory_chat(t *testing.T) {
+	t.Run("no runs", func(t *testing.T) {
+		h := &History{messages: conversation("q1", "a1", "q2")}
+		be.Equal(t, h.chat(), []ai.Message{
+			{Role: roleUser, Content: "q1"},
+			{Role: roleAssistant, Content: "a1"},
+			{Role: roleUser, Content: "q2"},
+		})
+	})
+	t.Run("with run", func(t *testing.T) {
+		h := &History{messages: conversation("list files", "ls", "sort by size")}
+		h.messages[1].Run = &runResult{Command: "ls", Duration: 1500 * time.Millisecond, Stdout: "a.txt\nb.txt"}
+		be.Equal(t, h.chat(), []ai.Message{
+			{Role: roleUser, Content: "list files"},
+			{Role: roleAssistant, Content: "ls"},
+			{Role: roleUser, Content: "I ran `ls`: exit code 0, took 1.5s.\nThe output:\n```\na.txt\nb.txt\n```"},
+			{Role: roleUser, Content: "sort by size"},
+		})
+	})
+	t.Run("stdout and stderr", func(t *testing.T) {
+		h := &History{messages: conversation("list files", "ls a b")}
+		h.messages[1].Run = &runResult{Command: "ls a b", ExitCode: 2, Stdout: "a", Stderr: "ls: b: No such file"}
+		be.Equal(t, h.chat()[2].Content, "I ran `ls a b`: exit code 2, took 0s."+
+			"\nThe output:\n```\na\n```\nThe error output:\n```\nls: b: No such file\n```")
+	})
+	t.Run("stopped", func(t *testing.T) {
+		h := &History{messages: conversation("wait", "sleep 30")}
+		h.messages[1].Run = &runResult{Command: "sleep 30", ExitCode: -1, Error: "the command was interrupted"}
+		be.Equal(t, h.chat()[2].Content, "I ran `sleep 30`: exit code -1, took 0s. Error: the command was interrupted. There was no output.")
+	})
+	t.Run("no output", func(t *testing.T) {
+		h := &History{messages: conversation("remove", "rm a.txt")}
+		h.messages[1].Run = &runResult{Command: "rm a.txt", ExitCode: 1}
+		be.Equal(t, h.chat()[2].Content, "I ran `rm a.txt`: exit code 1, took 0s. There was no output.")
+	})
+	t.Run("edited", func(t *testing.T) {
+		h := &History{messages: conversation("remove", "rm a.txt")}
+		h.messages[1].Run = &runResult{Command: "rm -f a.txt", Edited: true}
+		be.Equal(t, h.chat()[2].Content, "I edited the command and ran `rm -f a.txt`: exit code 0, took 0s. There was no output.")
+	})
+}
+
+func TestHistory_recordRun(t *testing.T) {
+	h := &History{messages: conversation("q1", "a1", "q2", "a2")}
+	h.recordRun(runResult{Command: "a2", ExitCode: 2})
+	be.True(t, h.messages[1].Run == nil)
+	be.Equal(t, h.messages[3].Run.ExitCode, 2)
 }
 
 func TestHistory_Save(t *testing.T) {
@@ -114,8 +208,8 @@ func TestHistory_Save(t *testing.T) {
 	be.Equal(t, hist.path, path)
 
 	// Add messages
-	hist.Add("test question")
-	hist.Add("test answer")
+	hist.Add(newQuestion("test question"))
+	hist.Add(newAnswer("test answer"))
 
 	// Save
 	err = hist.Save()
@@ -124,18 +218,41 @@ func TestHistory_Save(t *testing.T) {
 	// Load again
 	hist2, err := loadHistory(path)
 	be.Err(t, err, nil)
-	be.Equal(t, hist.messages, hist2.messages)
+	be.Equal(t, contents(hist2.messages), contents(hist.messages))
+	be.Equal(t, hist2.messages[1].Role, roleAssistant)
 }
 
 func TestHistory_Save_error(t *testing.T) {
 	h := &History{path: "/invalid/path/test_history.json"}
-	h.Add("test question")
-	h.Add("test answer")
+	h.Add(newQuestion("test question"))
+	h.Add(newAnswer("test answer"))
 
 	err := h.Save()
 	be.Err(t, err)
 }
 
+func TestHistory_Save_limit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test_history.json")
+	hist, err := loadHistory(path)
+	be.Err(t, err, nil)
+
+	// Each pair of messages is about 100 KB.
+	big := strings.Repeat("x", 100*1024)
+	hist.messages = conversation("q1", big, "q2", big, "q3", big)
+	err = hist.Save()
+	be.Err(t, err, nil)
+
+	info, err := os.Stat(path)
+	be.Err(t, err, nil)
+	be.True(t, info.Size() <= maxHistorySize)
+
+	hist2, err := loadHistory(path)
+	be.Err(t, err, nil)
+	be.Equal(t, len(hist2.messages), 4)
+	be.Equal(t, hist2.messages[0].Content, "q2")
+	be.Equal(t, hist2.messages[2].Content, "q3")
+}
+
 func Test_getHistoryPath(t *testing.T) {
 	// Save current environment variables and restore them after the test.
 	oldEnv := map[string]string{}
@@ -195,7 +312,74 @@ func Test_loadHistory(t *testing.T) {
 
 	// Check messages
 	wantMessages := []string{"test question", "test answer"}
-	be.Equal(t, hist.messages, wantMessages)
+	be.Equal(t, contents(hist.messages), wantMessages)
+}
+
+func Test_loadHistory_versioned(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test_history.json")
+
+	// Create a history file
+	data := `{"version": 1, "messages": [
+		{"role": "user", "content": "test question", "time": "2025-02-09T12:00:00Z", "cwd": "/tmp"},
+		{"role": "assistant", "content": "test answer", "time": "2025-02-09T12:00:01Z", "vendor": "openai", "model": "gpt-4o"}
+	]}`
+	err := os.WriteFile(path, []byte(data), 0600)
+	be.Err(t, err, nil)
+
+	// Load history
+	hist, err := loadHistory(path)
+	be.Err(t, err, nil)
+
+	// Check messages
+	be.Equal(t, len(hist.messages), 2)
+	be.Equal(t, hist.messages[0].Role, roleUser)
+	be.Equal(t, hist.messages[0].Cwd, "/tmp")
+	be.Equal(t, hist.messages[1].Role, roleAssistant)
+	be.Equal(t, hist.messages[1].Model, "gpt-4o")
+	be.Equal(t, hist.messages[1].Time, time.Date(2025, 2, 9, 12, 0, 1, 0, time.UTC))
+}
+
+func Test_loadHistory_newerVersion(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test_history.json")
+
+	// Create a history file from the future
+	err := os.WriteFile(path, []byte(`{"version": 99, "messages": []}`), 0600)
+	be.Err(t, err, nil)
+
+	// Load history
+	_, err = loadHistory(path)
+	be.Err(t, err, errHistoryVersion)
+
+	// The file is left intact
+	_, err = os.Stat(path)
+	be.Err(t, err, nil)
+}
+
+func TestHistory_Save_migrates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test_history.json")
+
+	// Create a legacy history file
+	err := os.WriteFile(path, []byte(`["test question", "test answer"]`), 0600)
+	be.Err(t, err, nil)
+
+	// Load and save history
+	hist, err := loadHistory(path)
+	be.Err(t, err, nil)
+	err = hist.Save()
+	be.Err(t, err, nil)
+
+	// Check the new format
+	data, err := os.ReadFile(path)
+	be.Err(t, err, nil)
+	var file historyFile
+	err = json.Unmarshal(data, &file)
+	be.Err(t, err, nil)
+	be.Equal(t, file.Version, historyVersion)
+	be.Equal(t, contents(file.Messages), []string{"test question", "test answer"})
+	be.Equal(t, file.Messages[1].Role, roleAssistant)
 }
 
 func Test_loadHistory_notExists(t *testing.T) {
@@ -219,8 +403,27 @@ func Test_loadHistory_invalidJSON(t *testing.T) {
 	be.Err(t, err, nil)
 
 	// Load history
-	_, err = loadHistory(path)
-	be.Err(t, err, "invalid character")
+	hist, err := loadHistory(path)
+	be.Err(t, err, nil)
+	be.Equal(t, len(hist.messages), 0)
+	be.True(t, strings.Contains(hist.warning, "history file is corrupt"))
+
+	// The corrupt file is backed up
+	backups, err := filepath.Glob(path + ".*.bak")
+	be.Err(t, err, nil)
+	be.Equal(t, len(backups), 1)
+	data, err := os.ReadFile(backups[0])
+	be.Err(t, err, nil)
+	be.Equal(t, string(data), "invalid json")
+
+	// The history is usable again
+	hist.Add(newQuestion("test question"))
+	err = hist.Save()
+	be.Err(t, err, nil)
+	hist, err = loadHistory(path)
+	be.Err(t, err, nil)
+	be.Equal(t, contents(hist.messages), []string{"test question"})
+	be.Equal(t, hist.warning, "")
 }
 
 func Test_loadHistory_readonly(t *testing.T) {
@@ -258,6 +461,7 @@ func TestLoadHistory(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		dir := t.TempDir()
 		_ = os.Setenv("HOME", dir)
+		_ = os.Setenv("HOWTO_SESSION", globalTerminal)
 
 		// Create a dummy history file.
 		historyPath, err := getHistoryPath()
@@ -275,6 +479,47 @@ func TestLoadHistory(t *testing.T) {
 
 		// Check messages.
 		wantMessages := []string{"test question", "test answer"}
-		be.Equal(t, hist.messages, wantMessages)
+		be.Equal(t, contents(hist.messages), wantMessages)
+	})
+
+	t.Run("terminal", func(t *testing.T) {
+		dir := t.TempDir()
+		_ = os.Setenv("HOME", dir)
+		_ = os.Setenv("HOWTO_SESSION", "pane1")
+
+		// Save the history in the terminal.
+		hist, err := LoadHistory()
+		be.Err(t, err, nil)
+		hist.Add(newQuestion("test question"))
+		hist.Add(newAnswer("test answer"))
+		err = hist.Save()
+		be.Err(t, err, nil)
+		be.True(t, strings.HasSuffix(hist.path, "/terminals/env-pane1.json"))
+
+		// Another terminal has its own history.
+		_ = os.Setenv("HOWTO_SESSION", "pane2")
+		hist, err = LoadHistory()
+		be.Err(t, err, nil)
+		be.Equal(t, len(hist.messages), 0)
+
+		// The first terminal keeps its history.
+		_ = os.Setenv("HOWTO_SESSION", "pane1")
+		hist, err = LoadHistory()
+		be.Err(t, err, nil)
+		be.Equal(t, contents(hist.messages), []string{"test question", "test answer"})
 	})
 }
+
+// conversation creates a list of alternating user and assistant messages.
+func conversation(contents ...string) []message {
+	return migrateHistory(contents)
+}
+
+// contents returns the contents of the messages.
+func contents(messages []message) []string {
+	var result []string
+	for _, msg := range messages {
+		result = append(result, msg.Content)
+	}
+	return result
+}
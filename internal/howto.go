@@ -2,30 +2,135 @@ package internal
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/nalgeon/howto/internal/ai"
 )
 
+// Name of the directory containing the cached answers.
+const cacheDirName = "cache"
+
 // howto implements the howto command.
-// Uses the given ask function to get an answer from the AI.
+// Uses the given ask function to get an answer from the AI,
+// and the embed function (if any) to recall similar past answers.
+// Reads piped context from the given reader.
 // Prints all output to the given writer.
-func Howto(out io.Writer, ask ai.AskFunc, ver Version, args []string, history *History) error {
-	input := strings.Join(args, " ")
+func Howto(in io.Reader, out io.Writer, ask ai.AskFunc, embed ai.EmbedFunc, ver Version, args []string, history *History) error {
+	if history.warning != "" {
+		fprintln(out, "WARNING:", history.warning)
+	}
 
-	var err error
-	switch input {
-	case "-h", "--help":
+	opts, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	if opts.sandbox {
+		sandbox = true
+	}
+
+	if opts.session != "" && opts.command == "" && opts.question == "" {
+		// Switch to the session and make it current.
+		history, err = switchSession(history.dir, history.terminal, opts.session)
+		if err != nil {
+			return err
+		}
+		fprintln(out, "Switched to session", history.sessionName())
+		return nil
+	}
+	if opts.session != "" {
+		// Use the session for this command only.
+		history, err = loadSession(history.dir, history.terminal, opts.session)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch opts.command {
+	case "-h":
 		PrintUsage(out)
-	case "-v", "--version":
+	case "-v":
 		printVersion(out, ver, ai.Conf, history)
 	case "-run":
-		err = runCommand(out, history)
+		editor := newLineEditor(in, out)
+		if opts.arg != "" {
+			err = runArchived(out, editor, ask, history, opts.arg)
+		} else {
+			err = runCommand(out, editor, ask, history)
+		}
+	case "-log":
+		err = printLog(out, history, opts.arg)
+	case "-search":
+		err = printSearch(out, history, opts.arg)
+	case "-sessions":
+		err = printSessions(out, history)
+	case "-show":
+		err = showSession(out, history, opts.arg)
+	case "-i":
+		err = repl(in, out, ask, history, opts.noCache)
+	case "-copy":
+		err = copyCommand(out, history)
+	case "-undo":
+		err = undo(out, history)
+	case "-retry":
+		err = retry(out, ask, history, opts.arg)
+	case "-branch":
+		history, err = branch(out, history, opts.arg)
+	case "-plan":
+		var context []contextBlock
+		context, err = readContext(out, opts.stdin(in), opts.files)
+		if err != nil {
+			return err
+		}
+		if !opts.noCache && history.dir != "" {
+			ask = withCache(ask, history)
+		}
+		err = plan(in, out, ask, opts.arg, context, history.dir, opts.output)
+	case "-export":
+		err = export(out, history, opts.arg)
+	case "-clear-cache":
+		return clearCache(out, history)
+	case "-delete":
+		// Nothing to save, the session is gone.
+		return deleteSession(history.dir, history.terminal, opts.arg)
 	default:
-		err = answer(out, ask, input, history)
+		if opts.question == "" {
+			return fmt.Errorf("missing question")
+		}
+		var context []contextBlock
+		context, err = readContext(out, opts.stdin(in), opts.files)
+		if err != nil {
+			return err
+		}
+		if history.dir == "" {
+			embed = nil
+		} else if !opts.noCache {
+			ask = withCache(ask, history)
+		}
+		start := time.Now()
+		err = answer(out, ask, embed, !opts.noCache, opts.question, context, history)
+		answered := err == nil && answeredSince(history, start)
+		if answered && copyEnabled() && history.LastCommand() != "" {
+			if copyErr := copyCommand(out, history); copyErr != nil {
+				// The answer is still useful without the clipboard.
+				fprintln(out, "WARNING:", copyErr)
+			}
+		}
+		if answered && showMenu(in, out, opts) {
+			// Save the answer first, in case the action fails.
+			if err = history.Save(); err != nil {
+				return err
+			}
+			err = menu(in, out, ask, history)
+		}
 	}
 
 	if err != nil {
@@ -35,27 +140,100 @@ func Howto(out io.Writer, ask ai.AskFunc, ver Version, args []string, history *H
 	return history.Save()
 }
 
-// answer asks the AI a question and prints the answer.
-func answer(out io.Writer, ask ai.AskFunc, input string, history *History) error {
+// answer asks the AI a question (with optional context) and prints the answer.
+// Sends the full context to the AI, but stores it in the history
+// in a truncated form. If the embed function is set, indexes the question,
+// and if recall is enabled, first looks up a similar question asked before
+// and answers with its answer instead.
+func answer(out io.Writer, ask ai.AskFunc, embed ai.EmbedFunc, recall bool, input string, blocks []contextBlock, history *History) error {
 	if ask == nil {
 		return fmt.Errorf("ask function is not set")
 	}
 
-	if strings.HasPrefix(input, "+") {
+	followUp := strings.HasPrefix(input, "+")
+	if followUp {
 		input = strings.TrimSpace(input[1:])
-	} else {
+	}
+
+	// Never send or store the secrets, only the placeholders.
+	redactor, err := newRedactor(os.Getenv("HOWTO_REDACT"), history.messages)
+	if err != nil {
+		return err
+	}
+	input = redactor.redact(input)
+	for i := range blocks {
+		blocks[i].content = redactor.redact(blocks[i].content)
+	}
+
+	if !followUp {
 		history.Clear()
 	}
 
-	history.Add(input)
-	answer, err := ask(history.messages)
+	var vector []float32
+	if embed != nil && !followUp && len(blocks) == 0 {
+		vector = embedQuestion(out, embed, input)
+		if recall && vector != nil {
+			if entry, found := recallSimilar(out, history.dir, vector); found {
+				history.Add(newQuestion(input))
+				history.Add(recalledAnswer(entry))
+				return nil
+			}
+		}
+	}
+
+	question := newQuestion(buildQuestion(input, blocks, maxStoredContext))
+	history.Add(question)
+	messages := history.chat()
+	if len(blocks) > 0 {
+		limit := fitContext(ai.Conf, input, blocks)
+		messages[len(messages)-1].Content = buildQuestion(input, blocks, limit)
+	}
+
+	answer, err := ask(context.Background(), messages)
 	if err != nil {
 		return err
 	}
 
 	answer = removeFences(answer)
-	printAnswer(out, answer)
-	history.Add(answer)
+	printAnswer(out, redactor.restore(answer))
+	addAnswer(out, history, question, answer, vector)
+	return nil
+}
+
+// addAnswer adds the answer to the question to the history,
+// the archive and the index (if the question embedding is given).
+func addAnswer(out io.Writer, history *History, question message, answer string, vector []float32) {
+	msg := newAnswer(answer)
+	var err error
+	msg.ID, err = archiveAnswer(history.dir, question, msg)
+	if err != nil {
+		// The answer is still useful without the archive.
+		fprintln(out, "WARNING:", err)
+	}
+	err = indexQuestion(history.dir, msg.ID, ai.Conf.EmbedModel, vector)
+	if err != nil {
+		fprintln(out, "WARNING:", err)
+	}
+	history.Add(msg)
+}
+
+// answeredSince reports whether the AI has answered after the given time
+// (as opposed to answering with a recalled answer or not at all).
+func answeredSince(history *History, t time.Time) bool {
+	msg, ok := history.lastAnswer()
+	return ok && !msg.Time.Before(t)
+}
+
+// clearCache removes all cached answers.
+func clearCache(out io.Writer, history *History) error {
+	if history.dir == "" {
+		return fmt.Errorf("cache is not available")
+	}
+	err := ai.NewCache(filepath.Join(history.dir, cacheDirName), ai.Conf).Clear()
+	if err != nil {
+		return fmt.Errorf("clear cache: %w", err)
+	}
+	fprintln(out, "Cache cleared")
 	return nil
 }
 
@@ -80,51 +258,167 @@ func removeFences(s string) string {
 func printAnswer(out io.Writer, answer string) {
 	command, rest, ok := strings.Cut(answer, "\n")
 	if !ok {
-		printWrapped(out, answer, 80)
+		printWrapped(out, renderMarkdown(answer), terminalWidth())
 		return
 	}
-	_, _ = fmt.Fprintln(out, bold(command))
-	printWrapped(out, rest, 80)
+	_, _ = fmt.Fprintln(out, highlight(command))
+	printWrapped(out, renderMarkdown(rest), terminalWidth())
 }
 
-// runCommand runs the last suggested command.
-func runCommand(out io.Writer, history *History) error {
+// runCommand runs the last suggested command. If the command
+// modifies files, shows a preview and asks for confirmation first.
+func runCommand(out io.Writer, editor *lineEditor, ask ai.AskFunc, history *History) error {
+	src, ok := lastSource(history)
 	cmd := history.LastCommand()
-	if cmd == "" {
+	if !ok || cmd == "" {
 		return fmt.Errorf("no command to run")
 	}
+	return runConfirmed(out, editor, ask, history, src, cmd)
+}
 
-	_, _ = fmt.Fprintln(out, bold(cmd))
-	_, _ = fmt.Fprintln(out)
-	output, err := execCommand(cmd)
+// runConfirmed previews the command if it modifies files,
+// asks for confirmation, and runs it. If the command belongs
+// to the last answer, records the result in the history.
+func runConfirmed(out io.Writer, editor *lineEditor, ask ai.AskFunc, history *History, src runSource, cmd string) error {
+	ok, err := confirmRun(out, editor, ask, cmd)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fprintln(out, "Cancelled")
+		return nil
+	}
+	result, err := run(out, history.dir, src, cmd)
+	if msg, ok := history.lastAnswer(); ok && msg.ID == src.id && result != nil {
+		if recErr := saveRun(history, *result); recErr != nil {
+			fprintln(out, "WARNING:", recErr)
+		}
+	}
+	return err
+}
+
+// saveRun stores the run result in the last answer and saves
+// the history. Truncates the output and redacts the secrets,
+// same as with the questions.
+func saveRun(history *History, result runResult) error {
+	redactor, err := newRedactor(os.Getenv("HOWTO_REDACT"), history.messages)
 	if err != nil {
 		return err
 	}
-	_, _ = fmt.Fprintln(out, output)
+	truncate := func(s string) string {
+		if len(s) > maxRunOutput {
+			s = truncateBytes(s, maxRunOutput) + "\n... (truncated)"
+		}
+		return redactor.redact(s)
+	}
+	result.Command = redactor.redact(result.Command)
+	result.Stdout = truncate(result.Stdout)
+	result.Stderr = truncate(result.Stderr)
+	history.recordRun(result)
+	return history.Save()
+}
+
+// checkRedacted returns an error if the command contains placeholders
+// of the redacted secrets. The secrets are never stored, so such a command
+// can't be run as is.
+func checkRedacted(cmd string) error {
+	if ph := placeholderRe.FindString(cmd); ph != "" {
+		return fmt.Errorf("the command contains a redacted secret (%s), run it manually", ph)
+	}
 	return nil
 }
 
-// execCommand executes a shell command and returns the output.
-func execCommand(command string) (string, error) {
+// run runs the command, prints the output, records the exit code
+// in the archive entry of the source (unless the user edited the command),
+// and adds the run to the audit log.
+// Returns the run result, or nil if the command did not run.
+func run(out io.Writer, dir string, src runSource, cmd string) (*runResult, error) {
+	if err := checkRedacted(cmd); err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintln(out, highlight(cmd))
+	_, _ = fmt.Fprintln(out)
+	// Log the command before it runs, in case howto never gets to finish.
+	audit, auditErr := auditStart(dir, src, cmd)
+	if auditErr != nil {
+		fprintln(out, "WARNING:", auditErr)
+	}
+	start := time.Now()
+	output, code, err := execCommand(cmd)
+	result := &runResult{
+		Command:  cmd,
+		ExitCode: code,
+		Duration: time.Since(start),
+		Stdout:   output.stdout,
+		Stderr:   output.stderr,
+		Edited:   src.edited,
+	}
+	if err != nil && code < 0 {
+		result.Error = err.Error()
+	}
+	// The archive keeps the exit codes of the suggested commands,
+	// and the edited one is not what the AI suggested.
+	if !src.edited {
+		if archErr := archiveRun(dir, src.id, code); archErr != nil {
+			fprintln(out, "WARNING:", archErr)
+		}
+	}
+	if auditErr := auditFinish(dir, audit, result); auditErr != nil {
+		fprintln(out, "WARNING:", auditErr)
+	}
+	if err != nil {
+		return result, err
+	}
+	_, _ = fmt.Fprintln(out, output.stdout)
+	return result, nil
+}
+
+// commandOutput is the output of the executed command.
+type commandOutput struct {
+	stdout string
+	stderr string
+}
+
+// execCommand executes a shell command and returns the output
+// and the exit code. If the command fails, the error is its stderr.
+// The output is returned even if the command fails or is stopped.
+func execCommand(command string) (commandOutput, int, error) {
 	if command == "" {
-		return "", fmt.Errorf("empty command")
+		return commandOutput{}, -1, fmt.Errorf("empty command")
 	}
 
+	limits := loadRunLimits()
+	cmd, err := shellCommand(limits.wrap(command))
+	if err != nil {
+		return commandOutput{}, -1, err
+	}
 	var outb, errb bytes.Buffer
-
-	// Use the shell to execute the command and avoid parsing the arguments.
-	cmd := exec.Command("sh", "-c", command)
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
 
-	err := cmd.Run()
+	err = runProcess(cmd, limits.timeout)
+	output := commandOutput{
+		stdout: strings.TrimSpace(outb.String()),
+		stderr: strings.TrimSpace(errb.String()),
+	}
+	if errors.Is(err, errRunTimeout) || errors.Is(err, errRunInterrupted) {
+		return output, -1, err
+	}
 	if err != nil {
-		stderr := strings.TrimSpace(errb.String())
-		if stderr != "" {
-			return "", fmt.Errorf("%s", stderr)
+		code := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if sandbox {
+			return output, code, sandboxStartError(err)
+		}
+		if sbErr := sandboxError(code, errb.String()); sbErr != nil {
+			return output, code, sbErr
 		}
-		return "", err
+		if output.stderr != "" {
+			return output, code, fmt.Errorf("%s", output.stderr)
+		}
+		return output, code, err
 	}
-
-	return strings.TrimSpace(outb.String()), nil
+	return output, 0, nil
 }
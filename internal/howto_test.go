@@ -2,12 +2,14 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
 )
 
 func TestHowto(t *testing.T) {
@@ -15,67 +17,99 @@ func TestHowto(t *testing.T) {
 
 	t.Run("help", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "", nil
 		}
 		history := &History{}
-		err := Howto(out, ask, ver, []string{"-h"}, history)
+		err := Howto(nil, out, ask, nil, ver, []string{"-h"}, history)
 		be.Err(t, err, nil)
-		be.True(t, strings.Contains(out.String(), "Usage: howto [-h] [-v] [-run] [question]"))
+		be.True(t, strings.Contains(out.String(), "Usage: howto [-h] [-v] [-run] [-f file]... [-s session] [question]"))
 	})
 
 	t.Run("version", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "", nil
 		}
 		history := &History{}
-		err := Howto(out, ask, ver, []string{"-v"}, history)
+		err := Howto(nil, out, ask, nil, ver, []string{"-v"}, history)
 		be.Err(t, err, nil)
 		be.True(t, strings.Contains(out.String(), bold("howto")+" 1.2.3 (now)"))
 	})
 
 	t.Run("run command", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "", nil
 		}
 		history := &History{}
-		err := Howto(out, ask, ver, []string{"-run"}, history)
+		err := Howto(nil, out, ask, nil, ver, []string{"-run"}, history)
 		be.Err(t, err, "no command to run")
 	})
 
 	t.Run("answer", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "test command\ntest explanation", nil
 		}
 		history := &History{}
-		err := Howto(out, ask, ver, []string{"test"}, history)
+		err := Howto(nil, out, ask, nil, ver, []string{"test"}, history)
 		be.Err(t, err, nil)
-		be.True(t, strings.Contains(out.String(), bold("test command")))
+		be.True(t, strings.Contains(out.String(), highlight("test command")))
 		be.True(t, strings.Contains(out.String(), "test explanation"))
 	})
 
 	t.Run("answer with follow up", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "test command\ntest explanation", nil
 		}
-		history := &History{messages: []string{"test"}}
-		err := Howto(out, ask, ver, []string{"+test"}, history)
+		history := &History{messages: conversation("test")}
+		err := Howto(nil, out, ask, nil, ver, []string{"+test"}, history)
 		be.Err(t, err, nil)
-		be.True(t, strings.Contains(out.String(), bold("test command")))
+		be.True(t, strings.Contains(out.String(), highlight("test command")))
 		be.True(t, strings.Contains(out.String(), "test explanation"))
 	})
 
+	t.Run("answer with piped input", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		var question string
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			question = history[len(history)-1].Content
+			return "test command\ntest explanation", nil
+		}
+		history := &History{}
+		in := strings.NewReader("piped input")
+		err := Howto(in, out, ask, nil, ver, []string{"test"}, history)
+		be.Err(t, err, nil)
+		be.Equal(t, question, "test\n\n<context source=\"stdin\">\npiped input\n</context>")
+	})
+
+	t.Run("answer with large piped input", func(t *testing.T) {
+		defer func(conf ai.Config) { ai.Conf = conf }(ai.Conf)
+		ai.Conf.MaxTokens = 4000
+		out := &bytes.Buffer{}
+		var question string
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			question = history[len(history)-1].Content
+			return "test command\ntest explanation", nil
+		}
+		history := &History{}
+		in := strings.NewReader(strings.Repeat("piped input\n", 2000))
+		err := Howto(in, out, ask, nil, ver, []string{"test"}, history)
+		be.Err(t, err, nil)
+		// The input is truncated to fit into the token budget.
+		be.True(t, strings.Contains(question, "\n... (truncated)\n</context>"))
+		be.True(t, len(question)-len("test") <= ai.MaxExtraSize(ai.Conf, "test"))
+	})
+
 	t.Run("answer with error", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "", errors.New("test error")
 		}
 		history := &History{}
-		err := Howto(out, ask, ver, []string{"test"}, history)
+		err := Howto(nil, out, ask, nil, ver, []string{"test"}, history)
 		be.Err(t, err, "test error")
 	})
 }
@@ -83,37 +117,55 @@ func TestHowto(t *testing.T) {
 func Test_answer(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "test command\ntest explanation", nil
 		}
 		history := &History{}
-		err := answer(out, ask, "test", history)
+		err := answer(out, ask, nil, false, "test", nil, history)
 		be.Err(t, err, nil)
-		be.True(t, strings.Contains(out.String(), bold("test command")))
+		be.True(t, strings.Contains(out.String(), highlight("test command")))
 		be.True(t, strings.Contains(out.String(), "test explanation"))
 		be.Equal(t, len(history.messages), 2)
 	})
 
 	t.Run("follow up", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "test command\ntest explanation", nil
 		}
-		history := &History{messages: []string{"test"}}
-		err := answer(out, ask, "+test", history)
+		history := &History{messages: conversation("test")}
+		err := answer(out, ask, nil, false, "+test", nil, history)
 		be.Err(t, err, nil)
-		be.True(t, strings.Contains(out.String(), bold("test command")))
+		be.True(t, strings.Contains(out.String(), highlight("test command")))
 		be.True(t, strings.Contains(out.String(), "test explanation"))
 		be.Equal(t, len(history.messages), 3)
 	})
 
+	t.Run("with context", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		var question string
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			question = history[len(history)-1].Content
+			return "test command\ntest explanation", nil
+		}
+		history := &History{}
+		content := strings.Repeat("x", maxStoredContext+10)
+		context := []contextBlock{{source: "stdin", content: content}}
+		err := answer(out, ask, nil, false, "test", context, history)
+		be.Err(t, err, nil)
+		be.True(t, strings.Contains(question, content))
+		be.Equal(t, len(history.messages), 2)
+		be.True(t, !strings.Contains(history.messages[0].Content, content))
+		be.True(t, strings.Contains(history.messages[0].Content, "... (truncated)"))
+	})
+
 	t.Run("ask error", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		ask := func(history []string) (string, error) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
 			return "", errors.New("test error")
 		}
 		history := &History{}
-		err := answer(out, ask, "test", history)
+		err := answer(out, ask, nil, false, "test", nil, history)
 		be.Err(t, err, "test error")
 		be.Equal(t, len(history.messages), 1)
 	})
@@ -163,31 +215,75 @@ func Test_removeFences(t *testing.T) {
 func Test_runCommand(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		history := &History{messages: []string{"test", "echo test"}}
-		err := runCommand(out, history)
+		history := &History{messages: conversation("test", "echo test")}
+		err := runCommand(out, nil, nil, history)
 		be.Err(t, err, nil)
 		be.True(t, strings.Contains(out.String(), "test"))
+
+		run := history.messages[1].Run
+		be.True(t, run != nil)
+		be.Equal(t, run.Command, "echo test")
+		be.Equal(t, run.ExitCode, 0)
+		be.Equal(t, run.Stdout, "test")
+		be.Equal(t, run.Stderr, "")
 	})
 
 	t.Run("no command", func(t *testing.T) {
 		out := &bytes.Buffer{}
 		history := &History{}
-		err := runCommand(out, history)
+		err := runCommand(out, nil, nil, history)
 		be.Err(t, err, "no command to run")
 	})
 
 	t.Run("exec error", func(t *testing.T) {
 		out := &bytes.Buffer{}
-		history := &History{messages: []string{"test", "invalid command"}}
-		err := runCommand(out, history)
+		history := &History{messages: conversation("test", "invalid command")}
+		err := runCommand(out, nil, nil, history)
 		be.Err(t, err)
+
+		run := history.messages[1].Run
+		be.True(t, run != nil)
+		be.Equal(t, run.ExitCode, 127)
+		be.True(t, strings.Contains(run.Stderr, "not found"))
+	})
+
+	t.Run("stdout and stderr", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("test", "echo out; echo err >&2; exit 3")}
+		err := runCommand(out, nil, nil, history)
+		be.Err(t, err, "err")
+
+		run := history.messages[1].Run
+		be.Equal(t, run.ExitCode, 3)
+		be.Equal(t, run.Stdout, "out")
+		be.Equal(t, run.Stderr, "err")
+		be.Equal(t, run.Error, "")
+	})
+
+	t.Run("redacted output", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("test", "echo password=hunter2")}
+		err := runCommand(out, nil, nil, history)
+		be.Err(t, err, nil)
+		be.True(t, strings.Contains(out.String(), "hunter2"))
+		be.Equal(t, history.messages[1].Run.Stdout, "password=REDACTED_SECRET_1")
+	})
+
+	t.Run("truncated output", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("test", "yes | head -c 10000")}
+		err := runCommand(out, nil, nil, history)
+		be.Err(t, err, nil)
+		output := history.messages[1].Run.Stdout
+		be.True(t, strings.HasSuffix(output, "\n... (truncated)"))
+		be.True(t, len(output) < maxRunOutput+20)
 	})
 }
 
 func TestHowto_integration(t *testing.T) {
 	// Define a mock AI ask function for testing purposes.
-	ask := func(history []string) (string, error) {
-		question := history[len(history)-1]
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		question := history[len(history)-1].Content
 		switch question {
 		case "echo hello":
 			return "echo hello\n\nPrints hello to the console.", nil
@@ -203,26 +299,87 @@ func TestHowto_integration(t *testing.T) {
 
 	// Test case 1: Ask a question and check the output.
 	out := &bytes.Buffer{}
-	err := Howto(out, ask, ver, []string{"echo", "hello"}, history)
+	err := Howto(nil, out, ask, nil, ver, []string{"echo", "hello"}, history)
 	be.Err(t, err, nil)
-	wantStr1 := bold("echo hello") + "\n\n" + "Prints hello to the console." + "\n"
+	wantStr1 := highlight("echo hello") + "\n\n" + "Prints hello to the console." + "\n"
 	be.Equal(t, out.String(), wantStr1)
 
 	// Test case 2: Run the last command and check the output.
 	out.Reset()
-	err = Howto(out, ask, ver, []string{"-run"}, history)
+	err = Howto(nil, out, ask, nil, ver, []string{"-run"}, history)
 	be.Err(t, err, nil)
-	wantStr2 := bold("echo hello") + "\n\n" + "hello" + "\n"
+	wantStr2 := highlight("echo hello") + "\n\n" + "hello" + "\n"
 	be.Equal(t, out.String(), wantStr2)
 
 	// Test case 3: Ask a follow-up question and check the output.
 	out.Reset()
-	err = Howto(out, ask, ver, []string{"+echo", "world"}, history)
+	err = Howto(nil, out, ask, nil, ver, []string{"+echo", "world"}, history)
 	be.Err(t, err, nil)
-	wantStr3 := bold("echo world") + "\n\n" + "Prints world to the console." + "\n"
+	wantStr3 := highlight("echo world") + "\n\n" + "Prints world to the console." + "\n"
 	be.Equal(t, out.String(), wantStr3)
 
 	// Test case 4: Verify the history.
 	wantHistory := []string{"echo hello", "echo hello\n\nPrints hello to the console.", "echo world", "echo world\n\nPrints world to the console."}
-	be.Equal(t, history.messages, wantHistory)
+	be.Equal(t, contents(history.messages), wantHistory)
+}
+
+func TestHowto_cache(t *testing.T) {
+	ver := NewVersion("1.2.3", "commit", "now")
+	var calls int
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		calls++
+		return "ls -l\n\nLists files.", nil
+	}
+	history, err := loadSession(t.TempDir(), "", defaultSession)
+	be.Err(t, err, nil)
+	out := &bytes.Buffer{}
+
+	// The second answer comes from the cache.
+	err = Howto(nil, out, ask, nil, ver, []string{"list", "files"}, history)
+	be.Err(t, err, nil)
+	err = Howto(nil, out, ask, nil, ver, []string{"list", "files"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 1)
+
+	// Skip the cache.
+	err = Howto(nil, out, ask, nil, ver, []string{"--no-cache", "list", "files"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 2)
+
+	// Clear the cache.
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-clear-cache"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, out.String(), "Cache cleared\n")
+	err = Howto(nil, out, ask, nil, ver, []string{"list", "files"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 3)
+}
+
+func TestHowto_copy(t *testing.T) {
+	defer setColor(false)()
+	clipboard := fakeClipboard(t)
+	ver := NewVersion("1.2.3", "commit", "now")
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		return "ls -l\n\nLists files.", nil
+	}
+	history := &History{}
+
+	t.Run("copy", func(t *testing.T) {
+		history.messages = conversation("list files", "ls -la")
+		out := &bytes.Buffer{}
+		err := Howto(nil, out, ask, nil, ver, []string{"-copy"}, history)
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "Copied to clipboard\n")
+		be.Equal(t, readClipboard(t, clipboard), "ls -la")
+	})
+
+	t.Run("always copy", func(t *testing.T) {
+		t.Setenv("HOWTO_COPY", "on")
+		out := &bytes.Buffer{}
+		err := Howto(nil, out, ask, nil, ver, []string{"list", "files"}, history)
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "ls -l\n\nLists files.\nCopied to clipboard\n")
+		be.Equal(t, readClipboard(t, clipboard), "ls -l")
+	})
 }
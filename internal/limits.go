@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned when the command is stopped before it finishes.
+var (
+	errRunTimeout     = errors.New("the command timed out")
+	errRunInterrupted = errors.New("the command was interrupted")
+)
+
+// Time to wait for the command to exit after SIGTERM
+// before killing it with SIGKILL.
+var killDelay = 5 * time.Second
+
+// runLimits are the limits for running the suggested commands.
+// Zero means no limit.
+type runLimits struct {
+	// Maximum run time.
+	timeout time.Duration
+	// Maximum CPU time in seconds.
+	cpu int
+	// Maximum virtual memory in megabytes.
+	memory int
+}
+
+// loadRunLimits loads the run limits from the settings
+// (HOWTO_RUN_TIMEOUT, HOWTO_RUN_CPU and HOWTO_RUN_MEMORY).
+// Ignores invalid values.
+func loadRunLimits() runLimits {
+	var limits runLimits
+	if sec, err := strconv.Atoi(os.Getenv("HOWTO_RUN_TIMEOUT")); err == nil && sec > 0 {
+		limits.timeout = time.Duration(sec) * time.Second
+	}
+	if sec, err := strconv.Atoi(os.Getenv("HOWTO_RUN_CPU")); err == nil && sec > 0 {
+		limits.cpu = sec
+	}
+	if mb, err := strconv.Atoi(os.Getenv("HOWTO_RUN_MEMORY")); err == nil && mb > 0 {
+		limits.memory = mb
+	}
+	return limits
+}
+
+// wrap prepends the shell commands that set the resource limits
+// (if any) to the command. The limits apply to the command
+// and all the processes it starts.
+func (l runLimits) wrap(command string) string {
+	var b strings.Builder
+	if l.cpu > 0 {
+		fmt.Fprintf(&b, "ulimit -t %d || exit\n", l.cpu)
+	}
+	if l.memory > 0 {
+		fmt.Fprintf(&b, "ulimit -v %d || exit\n", l.memory*1024)
+	}
+	if b.Len() == 0 {
+		return command
+	}
+	b.WriteString(command)
+	return b.String()
+}
+
+// runProcess runs the command and waits for it to finish. If the command
+// runs longer than the timeout (if any), or the user presses Ctrl-C,
+// stops the command along with all the processes it started.
+func runProcess(cmd *exec.Cmd, timeout time.Duration) error {
+	restoreTerminal := setProcessGroup(cmd)
+	defer restoreTerminal()
+	// Don't wait forever for the output of the processes
+	// that survived the command.
+	cmd.WaitDelay = killDelay
+
+	// Ctrl-C should stop the command, not howto.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		stopProcess(cmd, done)
+		return fmt.Errorf("%w after %s (HOWTO_RUN_TIMEOUT)", errRunTimeout, timeout)
+	case <-sigs:
+		stopProcess(cmd, done)
+		return errRunInterrupted
+	}
+}
+
+// stopProcess asks the command and its child processes to exit,
+// and kills them if they don't exit in time.
+func stopProcess(cmd *exec.Cmd, done <-chan error) {
+	_ = terminateGroup(cmd)
+	select {
+	case <-done:
+	case <-time.After(killDelay):
+		_ = killGroup(cmd)
+		<-done
+	}
+}
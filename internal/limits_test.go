@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_loadRunLimits(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("HOWTO_RUN_TIMEOUT", "")
+		t.Setenv("HOWTO_RUN_CPU", "")
+		t.Setenv("HOWTO_RUN_MEMORY", "")
+		be.Equal(t, loadRunLimits(), runLimits{})
+	})
+	t.Run("custom", func(t *testing.T) {
+		t.Setenv("HOWTO_RUN_TIMEOUT", "60")
+		t.Setenv("HOWTO_RUN_CPU", "10")
+		t.Setenv("HOWTO_RUN_MEMORY", "512")
+		be.Equal(t, loadRunLimits(), runLimits{timeout: time.Minute, cpu: 10, memory: 512})
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("HOWTO_RUN_TIMEOUT", "soon")
+		t.Setenv("HOWTO_RUN_CPU", "-1")
+		t.Setenv("HOWTO_RUN_MEMORY", "1.5")
+		be.Equal(t, loadRunLimits(), runLimits{})
+	})
+}
+
+func Test_runLimits_wrap(t *testing.T) {
+	be.Equal(t, runLimits{}.wrap("ls -l"), "ls -l")
+	be.Equal(t, runLimits{timeout: time.Second}.wrap("ls -l"), "ls -l")
+	got := runLimits{cpu: 10, memory: 512}.wrap("ls -l")
+	be.Equal(t, got, "ulimit -t 10 || exit\nulimit -v 524288 || exit\nls -l")
+}
+
+func Test_runProcess(t *testing.T) {
+	old := killDelay
+	killDelay = 500 * time.Millisecond
+	defer func() { killDelay = old }()
+
+	t.Run("success", func(t *testing.T) {
+		cmd := exec.Command("sh", "-c", "exit 3")
+		err := runProcess(cmd, time.Second)
+		var exitErr *exec.ExitError
+		be.True(t, errors.As(err, &exitErr))
+		be.Equal(t, exitErr.ExitCode(), 3)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		// The child process keeps the output open,
+		// so the whole group must be stopped.
+		cmd := exec.Command("sh", "-c", "sleep 30 & wait")
+		cmd.Stdout = &discard{}
+		start := time.Now()
+		err := runProcess(cmd, 100*time.Millisecond)
+		be.Err(t, err, "the command timed out after 100ms (HOWTO_RUN_TIMEOUT)")
+		be.True(t, time.Since(start) < killDelay)
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		// The command ignores SIGTERM, so it's killed.
+		cmd := exec.Command("sh", "-c", "trap '' TERM; sleep 30 & wait; sleep 30")
+		cmd.Stdout = &discard{}
+		start := time.Now()
+		err := runProcess(cmd, 100*time.Millisecond)
+		be.Err(t, err, errRunTimeout)
+		elapsed := time.Since(start)
+		be.True(t, elapsed >= killDelay && elapsed < 3*killDelay)
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		// The stopped command is woken up to get the SIGTERM.
+		cmd := exec.Command("sh", "-c", "kill -STOP $$; sleep 30")
+		start := time.Now()
+		err := runProcess(cmd, 100*time.Millisecond)
+		be.Err(t, err, errRunTimeout)
+		be.True(t, time.Since(start) < killDelay)
+	})
+
+	t.Run("interrupt", func(t *testing.T) {
+		cmd := exec.Command("sleep", "30")
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			p, _ := os.FindProcess(os.Getpid())
+			_ = p.Signal(os.Interrupt)
+		}()
+		err := runProcess(cmd, 0)
+		be.Err(t, err, errRunInterrupted)
+	})
+}
+
+func Test_execCommand_limits(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		t.Setenv("HOWTO_RUN_TIMEOUT", "1")
+		out, code, err := execCommand("echo started >&2; sleep 30")
+		be.Err(t, err, "the command timed out after 1s (HOWTO_RUN_TIMEOUT)")
+		be.Equal(t, code, -1)
+		be.Equal(t, out.stderr, "started")
+	})
+	t.Run("resources", func(t *testing.T) {
+		t.Setenv("HOWTO_RUN_CPU", "5")
+		t.Setenv("HOWTO_RUN_MEMORY", "1024")
+		out, _, err := execCommand("ulimit -t; ulimit -v")
+		be.Err(t, err, nil)
+		be.Equal(t, out.stdout, "5\n1048576")
+	})
+}
+
+// discard is a writer that discards everything. Unlike io.Discard,
+// makes exec.Cmd create a pipe and wait for it to close.
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
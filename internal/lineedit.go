@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned when the user presses Ctrl-C
+// while editing the line.
+var errInterrupted = errors.New("interrupted")
+
+// Special keys (negative, so they never clash with characters).
+const (
+	keyUp rune = -iota - 1
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+// Control characters.
+const (
+	ctrlA     = 1
+	ctrlC     = 3
+	ctrlD     = 4
+	ctrlE     = 5
+	ctrlK     = 11
+	ctrlU     = 21
+	ctrlW     = 23
+	escape    = 27
+	backspace = 127
+)
+
+// lineEditor reads lines from the terminal with basic editing
+// (cursor movement, deletion) and the history of entered lines.
+// Falls back to reading plain lines if the input is not a terminal.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// Puts the terminal into raw mode and returns the function
+	// to restore it. Nil if the input is not a terminal.
+	raw func() (func(), error)
+	// Previously entered lines, oldest first.
+	history []string
+}
+
+// newLineEditor creates a line editor reading from the given input.
+func newLineEditor(in io.Reader, out io.Writer) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(in), out: out}
+	if f, ok := in.(*os.File); ok && isTerminal(f) && isTerminal(out) {
+		e.raw = func() (func(), error) { return makeRaw(f) }
+	}
+	return e
+}
+
+// readLine prints the prompt and reads a line, with the initial text
+// already entered. Returns io.EOF if the input is over (or the user
+// pressed Ctrl-D on an empty line), and errInterrupted on Ctrl-C.
+func (e *lineEditor) readLine(prompt, initial string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err == nil {
+			defer restore()
+			line, err := e.edit(prompt, initial)
+			_, _ = fmt.Fprint(e.out, "\r\n")
+			if err == nil && strings.TrimSpace(line) != "" {
+				e.history = append(e.history, line)
+			}
+			return line, err
+		}
+	}
+
+	_, _ = fmt.Fprint(e.out, prompt)
+	if initial != "" {
+		// Can't edit without a terminal, so show the text
+		// and ask for the new one.
+		_, _ = fmt.Fprintf(e.out, "(was: %s) ", initial)
+	}
+	line, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readChoice prints the prompt and reads a single key press.
+// Without a terminal, reads a line and returns its first character
+// (or Enter if the line is empty). Returns io.EOF on Ctrl-D
+// and errInterrupted on Ctrl-C.
+func (e *lineEditor) readChoice(prompt string) (rune, error) {
+	_, _ = fmt.Fprint(e.out, prompt)
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err == nil {
+			key, err := e.readKey()
+			restore()
+			_, _ = fmt.Fprint(e.out, "\r\n")
+			switch {
+			case err != nil:
+				return 0, err
+			case key == ctrlC:
+				return 0, errInterrupted
+			case key == ctrlD:
+				return 0, io.EOF
+			}
+			return key, nil
+		}
+	}
+
+	line, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return 0, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return '\r', nil
+	}
+	key, _ := utf8.DecodeRuneInString(line)
+	return key, nil
+}
+
+// edit reads the keys and edits the line until the user presses Enter.
+func (e *lineEditor) edit(prompt, initial string) (string, error) {
+	line := []rune(initial)
+	pos := len(line)
+	// Position in the history, len(history) is the line being edited.
+	hpos := len(e.history)
+	draft := line
+
+	for {
+		e.render(prompt, line, pos)
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case '\r', '\n':
+			return string(line), nil
+		case ctrlC:
+			return "", errInterrupted
+		case ctrlD:
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case backspace, '\b':
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case keyDelete:
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case keyLeft:
+			pos = max(pos-1, 0)
+		case keyRight:
+			pos = min(pos+1, len(line))
+		case keyHome, ctrlA:
+			pos = 0
+		case keyEnd, ctrlE:
+			pos = len(line)
+		case ctrlK:
+			line = line[:pos]
+		case ctrlU:
+			line = line[pos:]
+			pos = 0
+		case ctrlW:
+			start := pos
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case keyUp, keyDown:
+			if hpos == len(e.history) {
+				draft = line
+			}
+			if key == keyUp && hpos > 0 {
+				hpos--
+			} else if key == keyDown && hpos < len(e.history) {
+				hpos++
+			}
+			if hpos == len(e.history) {
+				line = draft
+			} else {
+				line = []rune(e.history[hpos])
+			}
+			pos = len(line)
+		default:
+			if key >= ' ' {
+				line = append(line[:pos], append([]rune{key}, line[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
+
+// render redraws the line and puts the cursor at the given position.
+func (e *lineEditor) render(prompt string, line []rune, pos int) {
+	col := displayWidth(prompt) + displayWidth(string(line[:pos]))
+	_, _ = fmt.Fprintf(e.out, "\r%s%s\033[K\r", prompt, string(line))
+	if col > 0 {
+		_, _ = fmt.Fprintf(e.out, "\033[%dC", col)
+	}
+}
+
+// readKey reads a single key press: a character, a control character,
+// or a special key sent as an escape sequence.
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != escape {
+		return r, err
+	}
+
+	// Escape sequences: ESC [ <params> <final> or ESC O <final>.
+	next, _, err := e.in.ReadRune()
+	if err != nil {
+		return keyUnknown, err
+	}
+	if next != '[' && next != 'O' {
+		return keyUnknown, nil
+	}
+	var params strings.Builder
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return keyUnknown, err
+		}
+		if c >= 0x40 && c <= 0x7e {
+			return escapeKey(params.String(), c), nil
+		}
+		params.WriteRune(c)
+	}
+}
+
+// escapeKey returns the special key for the escape sequence
+// with the given parameters and final character.
+func escapeKey(params string, final rune) rune {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+	return keyUnknown
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+// rawEditor creates a line editor that reads the keys
+// as if the input was a terminal in raw mode.
+func rawEditor(keys string) *lineEditor {
+	return &lineEditor{
+		in:  bufio.NewReader(strings.NewReader(keys)),
+		out: &bytes.Buffer{},
+		raw: func() (func(), error) { return func() {}, nil },
+	}
+}
+
+func Test_lineEditor_edit(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string
+		keys    string
+		want    string
+	}{
+		{"type", "", "hello\r", "hello"},
+		{"unicode", "", "привет 世界\r", "привет 世界"},
+		{"backspace", "", "helx\x7flo\r", "hello"},
+		{"left and insert", "", "hllo\x1b[D\x1b[D\x1b[De\r", "hello"},
+		{"home and end", "", "ello\x1b[Hh\x1b[F!\r", "hello!"},
+		{"ctrl-a and ctrl-e", "", "ello\x01h\x05!\r", "hello!"},
+		{"delete", "", "hello\x1b[H\x1b[3~\r", "ello"},
+		{"kill to end", "", "hello world\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x0b\r", "hello "},
+		{"kill to start", "", "hello world\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x15\r", "world"},
+		{"delete word", "", "hello big world\x17\x17there\r", "hello there"},
+		{"initial", "ls -l", "a\r", "ls -la"},
+		{"unknown escape", "", "hi\x1b[5~\x1bx!\r", "hi!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := rawEditor(tt.keys)
+			got, err := e.readLine("> ", tt.initial)
+			be.Err(t, err, nil)
+			be.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func Test_lineEditor_history(t *testing.T) {
+	e := rawEditor("one\rtwo\r\x1b[A\x1b[A\r\x1b[A\x1b[A\x1b[Bx\r")
+	for _, want := range []string{"one", "two", "one", "onex"} {
+		got, err := e.readLine("> ", "")
+		be.Err(t, err, nil)
+		be.Equal(t, got, want)
+	}
+}
+
+func Test_lineEditor_keys(t *testing.T) {
+	t.Run("ctrl-c", func(t *testing.T) {
+		_, err := rawEditor("hello\x03").readLine("> ", "")
+		be.True(t, errors.Is(err, errInterrupted))
+	})
+	t.Run("ctrl-d empty", func(t *testing.T) {
+		_, err := rawEditor("\x04").readLine("> ", "")
+		be.Err(t, err, io.EOF)
+	})
+	t.Run("ctrl-d deletes", func(t *testing.T) {
+		got, err := rawEditor("hello\x01\x04\r").readLine("> ", "")
+		be.Err(t, err, nil)
+		be.Equal(t, got, "ello")
+	})
+	t.Run("render", func(t *testing.T) {
+		e := rawEditor("hi\x1b[D\r")
+		_, err := e.readLine("> ", "")
+		be.Err(t, err, nil)
+		out := e.out.(*bytes.Buffer).String()
+		be.True(t, strings.HasSuffix(out, "\r> hi\033[K\r\033[3C\r\n"))
+	})
+}
+
+func Test_lineEditor_readChoice(t *testing.T) {
+	t.Run("raw", func(t *testing.T) {
+		e := rawEditor("rx")
+		key, err := e.readChoice("? ")
+		be.Err(t, err, nil)
+		be.Equal(t, key, 'r')
+		key, err = e.readChoice("? ")
+		be.Err(t, err, nil)
+		be.Equal(t, key, 'x')
+		be.Equal(t, e.out.(*bytes.Buffer).String(), "? \r\n? \r\n")
+	})
+	t.Run("raw keys", func(t *testing.T) {
+		_, err := rawEditor("\x03").readChoice("? ")
+		be.True(t, errors.Is(err, errInterrupted))
+		_, err = rawEditor("\x04").readChoice("? ")
+		be.Err(t, err, io.EOF)
+	})
+	t.Run("plain", func(t *testing.T) {
+		e := newLineEditor(strings.NewReader("run\n\n"), &bytes.Buffer{})
+		key, err := e.readChoice("? ")
+		be.Err(t, err, nil)
+		be.Equal(t, key, 'r')
+		key, err = e.readChoice("? ")
+		be.Err(t, err, nil)
+		be.Equal(t, key, '\r')
+		_, err = e.readChoice("? ")
+		be.Err(t, err, io.EOF)
+	})
+}
+
+func Test_lineEditor_plain(t *testing.T) {
+	e := newLineEditor(strings.NewReader("one\r\ntwo"), &bytes.Buffer{})
+	got, err := e.readLine("> ", "")
+	be.Err(t, err, nil)
+	be.Equal(t, got, "one")
+	got, err = e.readLine("> ", "")
+	be.Err(t, err, nil)
+	be.Equal(t, got, "two")
+	_, err = e.readLine("> ", "")
+	be.Err(t, err, io.EOF)
+}
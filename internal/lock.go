@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long to wait for a file lock before giving up.
+const lockTimeout = 5 * time.Second
+
+// How often to retry acquiring a file lock.
+const lockRetryDelay = 10 * time.Millisecond
+
+// withLock runs the function while holding an exclusive lock
+// on the file at the given path. The lock is a separate file
+// next to the locked one, so the locked file can be replaced.
+func withLock(path string, fn func() error) error {
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	return fn()
+}
+
+// writeFileAtomic writes the data to a temporary file
+// and then renames it to the given path, so that readers
+// never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// errLocked is returned when the lock is held by another process
+// for too long.
+func errLocked(path string) error {
+	return fmt.Errorf("%s is locked by another howto process", path)
+}
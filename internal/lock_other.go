@@ -0,0 +1,46 @@
+//go:build !linux && !darwin
+
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+// Lock files older than this are considered abandoned
+// by a crashed process and are removed.
+const staleLockAge = 30 * time.Second
+
+// fileLock is an exclusive lock based on the lock file existence.
+type fileLock struct {
+	path string
+}
+
+// lockFile acquires an exclusive lock by creating the file
+// at the given path. Waits for the lock up to the lock timeout.
+func lockFile(path string) (*fileLock, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_ = f.Close()
+			return &fileLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errLocked(path)
+		}
+		time.Sleep(lockRetryDelay)
+	}
+}
+
+// unlock releases the lock.
+func (l *fileLock) unlock() {
+	_ = os.Remove(l.path)
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_withLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	err := os.WriteFile(path, []byte("0"), 0600)
+	be.Err(t, err, nil)
+
+	// Increment the counter concurrently.
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := withLock(path, func() error {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				var n int
+				_, _ = fmt.Sscan(string(data), &n)
+				return writeFileAtomic(path, []byte(fmt.Sprint(n+1)))
+			})
+			be.Err(t, err, nil)
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	be.Err(t, err, nil)
+	be.Equal(t, string(data), "20")
+}
+
+func Test_writeFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.json")
+
+	err := writeFileAtomic(path, []byte("first"))
+	be.Err(t, err, nil)
+	err = writeFileAtomic(path, []byte("second"))
+	be.Err(t, err, nil)
+
+	data, err := os.ReadFile(path)
+	be.Err(t, err, nil)
+	be.Equal(t, string(data), "second")
+
+	// No temporary files left behind.
+	entries, err := os.ReadDir(dir)
+	be.Err(t, err, nil)
+	be.Equal(t, len(entries), 1)
+
+	info, err := os.Stat(path)
+	be.Err(t, err, nil)
+	be.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+}
+
+func TestHistory_Save_concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hist, err := loadHistory(path)
+			be.Err(t, err, nil)
+			hist.Clear()
+			hist.Add(newQuestion(fmt.Sprintf("question %d", i)))
+			hist.Add(newAnswer(fmt.Sprintf("answer %d", i)))
+			be.Err(t, hist.Save(), nil)
+		}()
+	}
+	wg.Wait()
+
+	hist, err := loadHistory(path)
+	be.Err(t, err, nil)
+	be.Equal(t, hist.warning, "")
+	be.Equal(t, len(hist.messages), 2)
+}
+
+func TestHistory_Save_merge(t *testing.T) {
+	// load returns two histories loaded from the same file
+	// with a single question and answer.
+	load := func(t *testing.T) (string, *History, *History) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		hist := &History{path: path}
+		hist.Add(newQuestion("q1"))
+		hist.Add(newAnswer("a1"))
+		be.Err(t, hist.Save(), nil)
+		h1, err := loadHistory(path)
+		be.Err(t, err, nil)
+		h2, err := loadHistory(path)
+		be.Err(t, err, nil)
+		return path, h1, h2
+	}
+
+	t.Run("follow-ups", func(t *testing.T) {
+		path, h1, h2 := load(t)
+		h1.Add(newQuestion("q2"))
+		h1.Add(newAnswer("a2"))
+		h2.Add(newQuestion("q3"))
+		h2.Add(newAnswer("a3"))
+		be.Err(t, h1.Save(), nil)
+		be.Err(t, h2.Save(), nil)
+
+		hist, err := loadHistory(path)
+		be.Err(t, err, nil)
+		be.Equal(t, contents(hist.messages), []string{"q1", "a1", "q2", "a2", "q3", "a3"})
+	})
+	t.Run("run result", func(t *testing.T) {
+		path, h1, h2 := load(t)
+		h1.recordRun(runResult{Command: "a1", ExitCode: 3})
+		h2.Add(newQuestion("q2"))
+		h2.Add(newAnswer("a2"))
+		be.Err(t, h2.Save(), nil)
+		be.Err(t, h1.Save(), nil)
+
+		hist, err := loadHistory(path)
+		be.Err(t, err, nil)
+		be.Equal(t, contents(hist.messages), []string{"q1", "a1", "q2", "a2"})
+		be.Equal(t, hist.messages[1].Run.ExitCode, 3)
+	})
+	t.Run("new conversation", func(t *testing.T) {
+		path, h1, h2 := load(t)
+		h1.Add(newQuestion("q2"))
+		h1.Add(newAnswer("a2"))
+		h2.Clear()
+		h2.Add(newQuestion("q3"))
+		h2.Add(newAnswer("a3"))
+		be.Err(t, h1.Save(), nil)
+		be.Err(t, h2.Save(), nil)
+
+		hist, err := loadHistory(path)
+		be.Err(t, err, nil)
+		be.Equal(t, contents(hist.messages), []string{"q3", "a3"})
+	})
+	t.Run("replaced conversation", func(t *testing.T) {
+		path, h1, h2 := load(t)
+		h1.Clear()
+		h1.Add(newQuestion("q9"))
+		h1.Add(newAnswer("a9"))
+		be.Err(t, h1.Save(), nil)
+		h2.Add(newQuestion("q2"))
+		h2.Add(newAnswer("a2"))
+		be.Err(t, h2.Save(), nil)
+
+		// The follow-up stays in the conversation it was asked in.
+		hist, err := loadHistory(path)
+		be.Err(t, err, nil)
+		be.Equal(t, contents(hist.messages), []string{"q1", "a1", "q2", "a2"})
+	})
+}
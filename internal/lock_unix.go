@@ -0,0 +1,46 @@
+//go:build linux || darwin
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileLock is an exclusive advisory lock on a file.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile acquires an exclusive lock on the file at the given path,
+// creating it if necessary. Waits for the lock up to the lock timeout.
+// The lock is released automatically if the process exits.
+func lockFile(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return &fileLock{f: f}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			_ = f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				err = errLocked(path)
+			}
+			return nil, err
+		}
+		time.Sleep(lockRetryDelay)
+	}
+}
+
+// unlock releases the lock.
+func (l *fileLock) unlock() {
+	_ = syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	_ = l.f.Close()
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// nbsp is a non-breaking space. Used in place of regular spaces
+// in inline code, so that the wrapper never breaks it.
+const nbsp = "\u00a0"
+
+// renderMarkdown renders Markdown text for terminal output.
+// Supports headings, lists, inline code, emphasis and links.
+// If colors are disabled, strips the formatting and keeps the text
+// (inline code stays in backticks).
+func renderMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = renderLine(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderLine renders a single line of Markdown text.
+func renderLine(line string) string {
+	text := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(text)]
+
+	// Headings.
+	if level := headingLevel(text); level > 0 {
+		return indent + bold(renderInline(strings.TrimSpace(text[level:])))
+	}
+
+	// Unordered list bullets.
+	for _, bullet := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(text, bullet) {
+			if color {
+				bullet = "• "
+			}
+			return indent + bullet + renderInline(text[2:])
+		}
+	}
+
+	return indent + renderInline(text)
+}
+
+// headingLevel returns the level of the Markdown heading (1-6),
+// or 0 if the text is not a heading.
+func headingLevel(text string) int {
+	n := 0
+	for n < len(text) && text[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || n >= len(text) || text[n] != ' ' {
+		return 0
+	}
+	return n
+}
+
+// renderInline renders inline Markdown elements:
+// `code`, **bold**, *emphasis*, _emphasis_ and [links](url).
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '`':
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				break
+			}
+			code := s[i+1 : i+1+end]
+			b.WriteString(renderCode(code))
+			i += end + 2
+			continue
+
+		case strings.HasPrefix(s[i:], "**") || strings.HasPrefix(s[i:], "__"):
+			marker := s[i : i+2]
+			if inner, n, ok := emphasized(s, i, marker); ok {
+				b.WriteString(bold(renderInline(inner)))
+				i += n
+				continue
+			}
+
+		case s[i] == '*' || s[i] == '_':
+			marker := s[i : i+1]
+			if inner, n, ok := emphasized(s, i, marker); ok {
+				b.WriteString(italic(renderInline(inner)))
+				i += n
+				continue
+			}
+
+		case s[i] == '[':
+			if text, url, n, ok := parseLink(s[i:]); ok {
+				b.WriteString(renderLink(renderInline(text), url))
+				i += n
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// renderCode renders inline code. Highlights the code if colors
+// are enabled, otherwise keeps it in backticks.
+func renderCode(code string) string {
+	if color {
+		code = highlight(code)
+	} else {
+		code = "`" + code + "`"
+	}
+	return strings.ReplaceAll(code, " ", nbsp)
+}
+
+// renderLink renders a link as "text (url)",
+// or just "url" if the text is the same as the url.
+func renderLink(text, url string) string {
+	if text == url {
+		return underlined(url)
+	}
+	return text + " (" + underlined(url) + ")"
+}
+
+// emphasized checks if there is an emphasized text starting
+// at the given position and enclosed with the marker.
+// Returns the inner text and the total length including markers.
+func emphasized(s string, start int, marker string) (string, int, bool) {
+	open := start + len(marker)
+	if open >= len(s) || s[open] == ' ' {
+		return "", 0, false
+	}
+	// Underscores inside words (snake_case) are not emphasis.
+	if marker[0] == '_' && start > 0 && isWordChar(s, start-1) {
+		return "", 0, false
+	}
+	end := strings.Index(s[open:], marker)
+	if end <= 0 {
+		return "", 0, false
+	}
+	closing := open + end
+	if s[closing-1] == ' ' {
+		return "", 0, false
+	}
+	after := closing + len(marker)
+	if marker[0] == '_' && after < len(s) && isWordChar(s, after) {
+		return "", 0, false
+	}
+	return s[open:closing], after - start, true
+}
+
+// isWordChar reports whether the character at the given position
+// is a letter or a digit.
+func isWordChar(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	if r == utf8.RuneError && i > 0 {
+		r, _ = utf8.DecodeLastRuneInString(s[:i+1])
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parseLink parses a [text](url) link at the beginning of the string.
+// Returns the text, the url and the total length of the link.
+func parseLink(s string) (string, string, int, bool) {
+	textEnd := strings.Index(s, "](")
+	if textEnd < 0 {
+		return "", "", 0, false
+	}
+	urlEnd := strings.IndexByte(s[textEnd+2:], ')')
+	if urlEnd < 0 {
+		return "", "", 0, false
+	}
+	text := s[1:textEnd]
+	url := s[textEnd+2 : textEnd+2+urlEnd]
+	if text == "" || url == "" || strings.ContainsAny(url, " \t") {
+		return "", "", 0, false
+	}
+	return text, url, textEnd + 2 + urlEnd + 1, true
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_renderMarkdown(t *testing.T) {
+	t.Run("no color", func(t *testing.T) {
+		defer setColor(false)()
+		tests := []struct {
+			name string
+			s    string
+			want string
+		}{
+			{"plain", "Prints hello.", "Prints hello."},
+			{"inline code", "The `-I` option", "The `-I` option"},
+			{"code with spaces", "Use `ls -la` here", "Use `ls" + nbsp + "-la` here"},
+			{"bold", "This is **important**", "This is important"},
+			{"emphasis", "Use *any* or _some_ file", "Use any or some file"},
+			{"snake case", "Set max_line_length", "Set max_line_length"},
+			{"glob", "Remove *.log and *.tmp", "Remove *.log and *.tmp"},
+			{"link", "See [docs](https://example.org)", "See docs (https://example.org)"},
+			{"same link", "[https://example.org](https://example.org)", "https://example.org"},
+			{"heading", "## Options", "Options"},
+			{"list", "- first\n  * second", "- first\n  * second"},
+			{"unclosed", "a `b and **c", "a `b and **c"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got := renderMarkdown(tt.s)
+				be.Equal(t, got, tt.want)
+			})
+		}
+	})
+
+	t.Run("color", func(t *testing.T) {
+		defer setColor(true)()
+		tests := []struct {
+			name string
+			s    string
+			want string
+		}{
+			{"inline code", "The `-I` option", "The \033[36m-I\033[0m option"},
+			{"bold", "This is **important**", "This is \033[1mimportant\033[0m"},
+			{"emphasis", "Use *any* file", "Use \033[3many\033[0m file"},
+			{"link", "See [docs](https://x.org)", "See docs (\033[4mhttps://x.org\033[0m)"},
+			{"list", "- first", "• first"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got := renderMarkdown(tt.s)
+				be.Equal(t, got, tt.want)
+			})
+		}
+	})
+}
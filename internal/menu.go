@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// Prompt of the action menu shown after the answer.
+const menuPrompt = "[r]un [e]dit [c]opy e[x]plain more [a]lternatives [q]uit "
+
+// menuKeys maps the action menu keys to the REPL commands
+// that implement the actions.
+var menuKeys = map[rune]string{
+	'r': "/run",
+	'e': "/edit",
+	'c': "/copy",
+	'x': "/explain",
+	'a': "/alternatives",
+}
+
+// menuEnabled reports whether the action menu is enabled
+// in the settings (HOWTO_MENU).
+func menuEnabled() bool {
+	switch strings.ToLower(os.Getenv("HOWTO_MENU")) {
+	case "off", "no", "false", "0":
+		return false
+	}
+	return true
+}
+
+// showMenu reports whether to show the action menu: only if both
+// the input and the output are terminals, and the menu is enabled.
+func showMenu(in io.Reader, out io.Writer, opts options) bool {
+	return !opts.noMenu && menuEnabled() && isTerminal(in) && isTerminal(out)
+}
+
+// menu shows the one-key action menu for the last suggested command
+// and executes the chosen action. Explanations and alternatives are
+// follow-ups, so the menu is shown again after them. Any other key
+// closes the menu.
+func menu(in io.Reader, out io.Writer, ask ai.AskFunc, history *History) error {
+	editor := newLineEditor(in, out)
+	for history.LastCommand() != "" {
+		key, err := editor.readChoice(italic(menuPrompt))
+		if errors.Is(err, errInterrupted) || err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		cmd, ok := menuKeys[unicode.ToLower(key)]
+		if !ok {
+			return nil
+		}
+		_, err = replCommand(out, editor, &ask, history, true, cmd) // ask is already cached by the caller
+		if err != nil {
+			return err
+		}
+		if cmd != "/explain" && cmd != "/alternatives" {
+			return nil
+		}
+	}
+	return nil
+}
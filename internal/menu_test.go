@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_menu(t *testing.T) {
+	defer setColor(false)()
+	var asked []string
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		question := history[len(history)-1].Content
+		asked = append(asked, question)
+		return "echo other\n\nAnother way.", nil
+	}
+
+	t.Run("run", func(t *testing.T) {
+		history := &History{messages: conversation("greet", "echo hello")}
+		out := &bytes.Buffer{}
+		err := menu(strings.NewReader("r\n"), out, ask, history)
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), menuPrompt+"echo hello\n\nhello\n")
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		clipboard := fakeClipboard(t)
+		history := &History{messages: conversation("greet", "echo hello")}
+		out := &bytes.Buffer{}
+		err := menu(strings.NewReader("C\n"), out, ask, history)
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), menuPrompt+"Copied to clipboard\n")
+		be.Equal(t, readClipboard(t, clipboard), "echo hello")
+	})
+
+	t.Run("follow-ups", func(t *testing.T) {
+		asked = nil
+		history := &History{messages: conversation("greet", "echo hello")}
+		out := &bytes.Buffer{}
+		err := menu(strings.NewReader("x\na\nq\nr\n"), out, ask, history)
+		be.Err(t, err, nil)
+		be.Equal(t, asked, []string{"Explain the command `echo hello` in detail: what each part and option does, and what can go wrong.", alternativesPrompt})
+		be.Equal(t, strings.Count(out.String(), menuPrompt), 3)
+		be.Equal(t, history.LastCommand(), "echo other")
+	})
+
+	t.Run("close", func(t *testing.T) {
+		for _, input := range []string{"q\n", "\n", "z\n", ""} {
+			history := &History{messages: conversation("greet", "echo hello")}
+			out := &bytes.Buffer{}
+			err := menu(strings.NewReader(input), out, ask, history)
+			be.Err(t, err, nil)
+			be.Equal(t, out.String(), menuPrompt)
+		}
+	})
+
+	t.Run("no command", func(t *testing.T) {
+		history := &History{}
+		out := &bytes.Buffer{}
+		err := menu(strings.NewReader("r\n"), out, ask, history)
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "")
+	})
+}
+
+func Test_showMenu(t *testing.T) {
+	// Buffers are not terminals.
+	be.Equal(t, showMenu(&bytes.Buffer{}, &bytes.Buffer{}, options{}), false)
+
+	t.Setenv("HOWTO_MENU", "off")
+	be.Equal(t, menuEnabled(), false)
+	t.Setenv("HOWTO_MENU", "on")
+	be.Equal(t, menuEnabled(), true)
+	t.Setenv("HOWTO_MENU", "")
+	be.Equal(t, menuEnabled(), true)
+}
+
+func Test_answeredSince(t *testing.T) {
+	start := time.Now()
+	history := &History{messages: []message{
+		{Role: roleUser, Content: "greet", Time: start.Add(-time.Minute)},
+		{Role: roleAssistant, Content: "echo hello", Time: start.Add(-time.Minute)},
+	}}
+	be.Equal(t, answeredSince(history, start), false)
+	history.Add(newAnswer("echo hi"))
+	be.Equal(t, answeredSince(history, start), true)
+	be.Equal(t, answeredSince(&History{}, start), false)
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// Prompt to ask for a multi-step plan instead of a single command.
+// Replaces the configured prompt, which asks for a single command.
+const planPrompt = `You are a command-line assistant. You help the user solve tasks ` +
+	`using command-line tools for the given platform (%s). ` +
+	`The task needs several commands. Reply with a plan: an ordered list of steps. ` +
+	`For each step, write the command on a separate line starting with "$ ", ` +
+	`followed by a short explanation on the next line. Separate the steps with a blank line. ` +
+	`Each command must fit on one line. Don't number the steps and don't use code fences.`
+
+// planCommandRe matches the command line of a plan step,
+// tolerating the numbering the AI sometimes adds.
+var planCommandRe = regexp.MustCompile(`^(?:\d+[.)]\s*)?\$\s+(.+)$`)
+
+// planStep is a single step of the plan.
+type planStep struct {
+	command     string
+	explanation string
+}
+
+// plan asks the AI for a multi-step plan for the task and prints it.
+// If the output file is given, saves the plan as a shell script.
+// Otherwise, if attached to a terminal, offers to run the steps one by one.
+func plan(in io.Reader, out io.Writer, ask ai.AskFunc, task string, blocks []contextBlock, dir, output string) error {
+	if ask == nil {
+		return fmt.Errorf("ask function is not set")
+	}
+
+	// Never send the secrets, only the placeholders.
+	redactor, err := newRedactor(os.Getenv("HOWTO_REDACT"), nil)
+	if err != nil {
+		return err
+	}
+	question := redactor.redact(task)
+	for i := range blocks {
+		blocks[i].content = redactor.redact(blocks[i].content)
+	}
+
+	config := ai.Conf
+	config.Prompt = fmt.Sprintf(planPrompt, runtime.GOOS)
+	messages := []ai.Message{
+		{Role: roleUser, Content: buildQuestion(question, blocks, fitContext(config, question, blocks))},
+	}
+	ctx := ai.WithPrompt(context.Background(), config.Prompt)
+	answer, err := ask(ctx, messages)
+	if err != nil {
+		return err
+	}
+	answer = redactor.restore(answer)
+
+	steps := parsePlan(answer)
+	if len(steps) == 0 {
+		printAnswer(out, removeFences(answer))
+		return fmt.Errorf("the answer is not a plan, try rephrasing the task")
+	}
+	printPlan(out, steps)
+
+	if output != "" {
+		if err := savePlan(output, task, steps); err != nil {
+			return err
+		}
+		fprintln(out, "Saved the plan to", output)
+		return nil
+	}
+	if isTerminal(in) && isTerminal(out) {
+		src := runSource{question: task, vendor: ai.Conf.Vendor, model: ai.Conf.Model}
+		return runPlan(out, newLineEditor(in, out), ask, dir, src, steps)
+	}
+	return nil
+}
+
+// parsePlan extracts the steps from the AI answer.
+// Lines that precede the first command are ignored.
+func parsePlan(answer string) []planStep {
+	var steps []planStep
+	for _, line := range strings.Split(removeFences(answer), "\n") {
+		line = strings.TrimSpace(line)
+		if m := planCommandRe.FindStringSubmatch(line); m != nil {
+			steps = append(steps, planStep{command: strings.TrimSpace(m[1])})
+			continue
+		}
+		if line == "" || len(steps) == 0 {
+			continue
+		}
+		step := &steps[len(steps)-1]
+		step.explanation = strings.TrimSpace(step.explanation + " " + line)
+	}
+	return steps
+}
+
+// printPlan prints the numbered steps of the plan.
+func printPlan(out io.Writer, steps []planStep) {
+	width := terminalWidth()
+	for i, step := range steps {
+		fprintln(out, bold(fmt.Sprintf("%d.", i+1)), highlight(step.command))
+		if step.explanation != "" {
+			printWrapped(out, "   "+renderMarkdown(step.explanation), width)
+		}
+	}
+}
+
+// runPlan runs the steps one by one, asking for confirmation
+// before each step and previewing destructive ones.
+// Stops on the first failed step.
+func runPlan(out io.Writer, editor *lineEditor, ask ai.AskFunc, dir string, src runSource, steps []planStep) error {
+	for i, step := range steps {
+		fprintln(out)
+		prompt := fmt.Sprintf("Run step %d? [y]es [s]kip [q]uit ", i+1)
+		key, err := editor.readChoice(italic(prompt))
+		if errors.Is(err, errInterrupted) || err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch unicode.ToLower(key) {
+		case 'y':
+			ok, err := confirmRun(out, editor, ask, step.command)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fprintln(out, "Skipped")
+				continue
+			}
+			if _, err := run(out, dir, src, step.command); err != nil {
+				return fmt.Errorf("step %d failed: %w", i+1, err)
+			}
+		case 's':
+			continue
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// savePlan saves the plan as an executable shell script
+// that stops on the first error.
+func savePlan(path, task string, steps []planStep) error {
+	var b strings.Builder
+	b.WriteString("#!/usr/bin/env bash\n")
+	fmt.Fprintf(&b, "# %s\n", commentLine(task))
+	b.WriteString("# Generated by howto.\n")
+	b.WriteString("set -euo pipefail\n")
+	for i, step := range steps {
+		b.WriteString("\n")
+		if step.explanation != "" {
+			fmt.Fprintf(&b, "# %d. %s\n", i+1, commentLine(step.explanation))
+		} else {
+			fmt.Fprintf(&b, "# %d.\n", i+1)
+		}
+		b.WriteString(step.command + "\n")
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0755); err != nil {
+		return fmt.Errorf("save plan: %w", err)
+	}
+	// WriteFile keeps the permissions of an existing file.
+	if err := os.Chmod(path, 0755); err != nil {
+		return fmt.Errorf("save plan: %w", err)
+	}
+	return nil
+}
+
+// commentLine makes the text safe to use in a single-line shell comment.
+func commentLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+const testPlan = `Here is the plan:
+
+$ mkdir -p demo
+Creates the directory.
+
+$ echo hello > demo/hello.txt
+Writes the greeting
+to a file.
+
+$ cat demo/hello.txt
+`
+
+func Test_plan(t *testing.T) {
+	defer setColor(false)()
+	var sent []ai.Message
+	var prompt string
+	ask := func(ctx context.Context, history []ai.Message) (string, error) {
+		sent = history
+		prompt, _ = ai.PromptFrom(ctx)
+		return testPlan, nil
+	}
+
+	t.Run("print", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		err := plan(nil, out, ask, "greet with token=abc12345", nil, "", "")
+		be.Err(t, err, nil)
+		// The plan prompt replaces the configured one.
+		be.Equal(t, prompt, fmt.Sprintf(planPrompt, runtime.GOOS))
+		be.Equal(t, sent, []ai.Message{{Role: "user", Content: "greet with token=REDACTED_SECRET_1"}})
+		want := "1. mkdir -p demo\n" +
+			"   Creates the directory.\n" +
+			"2. echo hello > demo/hello.txt\n" +
+			"   Writes the greeting to a file.\n" +
+			"3. cat demo/hello.txt\n"
+		be.Equal(t, out.String(), want)
+	})
+
+	t.Run("save", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "plan.sh")
+		out := &bytes.Buffer{}
+		err := plan(nil, out, ask, "greet", nil, "", path)
+		be.Err(t, err, nil)
+		be.True(t, strings.HasSuffix(out.String(), "Saved the plan to "+path+"\n"))
+
+		data, err := os.ReadFile(path)
+		be.Err(t, err, nil)
+		want := "#!/usr/bin/env bash\n" +
+			"# greet\n" +
+			"# Generated by howto.\n" +
+			"set -euo pipefail\n" +
+			"\n# 1. Creates the directory.\nmkdir -p demo\n" +
+			"\n# 2. Writes the greeting to a file.\necho hello > demo/hello.txt\n" +
+			"\n# 3.\ncat demo/hello.txt\n"
+		be.Equal(t, string(data), want)
+
+		stat, err := os.Stat(path)
+		be.Err(t, err, nil)
+		be.Equal(t, stat.Mode().Perm(), os.FileMode(0755))
+	})
+
+	t.Run("not a plan", func(t *testing.T) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			return "I can't help with that.", nil
+		}
+		out := &bytes.Buffer{}
+		err := plan(nil, out, ask, "greet", nil, "", "")
+		be.Err(t, err, "the answer is not a plan")
+		be.Equal(t, out.String(), "I can't help with that.\n")
+	})
+}
+
+func Test_parsePlan(t *testing.T) {
+	t.Run("numbered", func(t *testing.T) {
+		steps := parsePlan("```sh\n1. $ ls\nLists files.\n2) $ pwd\n```")
+		be.Equal(t, steps, []planStep{
+			{command: "ls", explanation: "Lists files."},
+			{command: "pwd"},
+		})
+	})
+	t.Run("empty", func(t *testing.T) {
+		be.Equal(t, len(parsePlan("Just text.")), 0)
+	})
+}
+
+func Test_runPlan(t *testing.T) {
+	defer setColor(false)()
+	newSteps := func(dir string) []planStep {
+		file := filepath.Join(dir, "hello.txt")
+		return []planStep{
+			{command: "echo hello > " + file},
+			{command: "echo skipped >> " + file},
+			{command: "false"},
+			{command: "echo unreachable >> " + file},
+		}
+	}
+
+	t.Run("run and skip", func(t *testing.T) {
+		dir := t.TempDir()
+		out := &bytes.Buffer{}
+		editor := newLineEditor(strings.NewReader("y\ns\ny\ny\n"), out)
+		err := runPlan(out, editor, nil, "", runSource{}, newSteps(dir))
+		be.Err(t, err, "step 3 failed")
+		data, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "hello\n")
+		be.True(t, strings.HasSuffix(out.String(), "Run step 3? [y]es [s]kip [q]uit false\n\n"))
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		dir := t.TempDir()
+		out := &bytes.Buffer{}
+		editor := newLineEditor(strings.NewReader("q\n"), out)
+		err := runPlan(out, editor, nil, "", runSource{}, newSteps(dir))
+		be.Err(t, err, nil)
+		be.Equal(t, out.String(), "\nRun step 1? [y]es [s]kip [q]uit ")
+		_, err = os.Stat(filepath.Join(dir, "hello.txt"))
+		be.True(t, os.IsNotExist(err))
+	})
+	t.Run("destructive", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "hello.txt")
+		be.Err(t, os.WriteFile(file, []byte("hello\n"), 0o644), nil)
+		editor := rawEditor("yn")
+		steps := []planStep{{command: "rm " + file}}
+		err := runPlan(editor.out, editor, nil, "", runSource{}, steps)
+		be.Err(t, err, nil)
+		got := editor.out.(*bytes.Buffer).String()
+		be.True(t, strings.Contains(got, "The command modifies files. Preview:"))
+		be.True(t, strings.HasSuffix(got, "Skipped\n"))
+		_, err = os.Stat(file)
+		be.Err(t, err, nil)
+	})
+}
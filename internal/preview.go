@@ -0,0 +1,437 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// Prompt to ask the AI for a read-only variant of the command.
+const previewPrompt = "Rewrite the command `%s` into a read-only variant that shows " +
+	"what the command would change (which files it would delete, move or modify) " +
+	"without changing anything. Reply with the command only."
+
+// Maximum size of the preview output to show.
+const maxPreviewSize = 4096
+
+// destructivePrograms lists the programs that always modify files.
+var destructivePrograms = map[string]bool{
+	"mv": true, "rename": true, "rm": true, "rmdir": true,
+	"shred": true, "truncate": true, "unlink": true,
+}
+
+// recursivePrograms lists the programs that modify
+// many files when used with -R.
+var recursivePrograms = map[string]bool{
+	"chgrp": true, "chmod": true, "chown": true,
+}
+
+// simpleCommand is a command without pipes or control operators,
+// split into words (wrappers like sudo are part of the prefix).
+type simpleCommand struct {
+	// Words before the program, e.g. sudo or LANG=C.
+	prefix []string
+	// Program name as written in the command.
+	program string
+	// Program arguments.
+	args []string
+}
+
+// name returns the base name of the program.
+func (c simpleCommand) name() string {
+	return filepath.Base(strings.TrimPrefix(c.program, "\\"))
+}
+
+// String returns the command as a shell string.
+func (c simpleCommand) String() string {
+	words := append(append(append([]string{}, c.prefix...), c.program), c.args...)
+	return strings.Join(words, " ")
+}
+
+// isDestructive reports whether the command modifies files:
+// deletes, moves or renames them, edits them in place,
+// or changes permissions recursively.
+func isDestructive(cmd string) bool {
+	for _, c := range splitCommands(cmd) {
+		if modifiesFiles(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiesFiles reports whether the simple command modifies files.
+func modifiesFiles(c simpleCommand) bool {
+	name := c.name()
+	switch {
+	case destructivePrograms[name]:
+		return true
+	case recursivePrograms[name]:
+		return hasFlag(c.args, 'R', "--recursive")
+	case name == "find":
+		for i, arg := range c.args {
+			if arg == "-delete" {
+				return true
+			}
+			if isExec(arg) && i+1 < len(c.args) {
+				// Check the program that find runs for each file.
+				prog := filepath.Base(c.args[i+1])
+				if destructivePrograms[prog] || recursivePrograms[prog] || prog == "sed" {
+					return true
+				}
+			}
+		}
+	case name == "sed":
+		i, _ := inPlaceFlag(c.args)
+		return i >= 0
+	}
+	return false
+}
+
+// splitCommands splits the shell command into simple commands
+// separated by pipes and control operators. Tokens with no space
+// between them make up a single word, e.g. $HOME/tmp or "$DIR"/a.txt.
+func splitCommands(cmd string) []simpleCommand {
+	var commands []simpleCommand
+	var cur simpleCommand
+	var words []string
+	flush := func() {
+		if cur.program != "" {
+			cur.args = words
+			commands = append(commands, cur)
+		}
+		cur, words = simpleCommand{}, nil
+	}
+	var inWord bool
+	for _, tok := range tokenize(cmd) {
+		isWord := tok.kind != tokSpace && tok.kind != tokComment &&
+			tok.kind != tokOperator && tok.kind != tokRedirect
+		if isWord && inWord {
+			// The token continues the previous word.
+			if len(words) > 0 {
+				words[len(words)-1] += tok.text
+			} else {
+				cur.program += tok.text
+			}
+			continue
+		}
+		inWord = isWord
+		switch {
+		case tok.kind == tokSpace || tok.kind == tokComment:
+		case tok.kind == tokOperator || tok.text == "|" || tok.text == "|&":
+			flush()
+		case tok.kind == tokProgram && cur.program != "" && len(words) == 0:
+			// The previous program was a wrapper, e.g. sudo.
+			cur.prefix = append(cur.prefix, cur.program)
+			cur.program = tok.text
+		case tok.kind == tokProgram && cur.program == "":
+			cur.prefix = words
+			cur.program = tok.text
+			words = nil
+		default:
+			words = append(words, tok.text)
+		}
+	}
+	flush()
+	return commands
+}
+
+// previewCommand returns a read-only variant of the command
+// that shows what the command would change. Only handles
+// single commands; returns false if there is no rewrite rule.
+func previewCommand(cmd string) (string, bool) {
+	for _, tok := range tokenize(cmd) {
+		if tok.kind == tokOperator || tok.kind == tokRedirect {
+			return "", false
+		}
+	}
+	commands := splitCommands(cmd)
+	if len(commands) != 1 {
+		return "", false
+	}
+	c := commands[0]
+	preview := simpleCommand{prefix: c.prefix}
+
+	switch name := c.name(); {
+	case name == "rm" || name == "rmdir" || name == "unlink" || name == "mv":
+		paths := positional(c.args)
+		if len(paths) == 0 {
+			return "", false
+		}
+		preview.program = "ls"
+		preview.args = append([]string{"-ld", "--"}, paths...)
+		if name == "rm" && (hasFlag(c.args, 'r', "--recursive") || hasFlag(c.args, 'R', "")) {
+			// Show all the files that would be deleted.
+			preview.program = "find"
+			preview.args = paths
+		}
+
+	case recursivePrograms[name]:
+		paths := positional(c.args)
+		if len(paths) < 2 {
+			return "", false
+		}
+		// Skip the mode or owner, show all the files that would change.
+		preview.program = "find"
+		preview.args = paths[1:]
+
+	case name == "rename":
+		preview.program = c.program
+		preview.args = append([]string{"-n"}, c.args...)
+
+	case name == "find":
+		preview.program = c.program
+		for _, arg := range c.args {
+			if isExec(arg) {
+				return "", false
+			}
+			if arg == "-delete" {
+				arg = "-print"
+			}
+			preview.args = append(preview.args, arg)
+		}
+
+	case name == "sed":
+		return previewSed(c)
+
+	default:
+		return "", false
+	}
+	return preview.String(), true
+}
+
+// previewSed returns the sed command without the in-place flag,
+// piped to diff to show the changes in each file.
+func previewSed(c simpleCommand) (string, bool) {
+	i, rest := inPlaceFlag(c.args)
+	if i < 0 {
+		return "", false
+	}
+	args := append([]string{}, c.args[:i]...)
+	if rest != "" {
+		args = append(args, rest)
+	}
+	args = append(args, c.args[i+1:]...)
+
+	// The script is the first positional argument,
+	// unless given with -e or -f. The rest are the files.
+	var script []string
+	var files []string
+	hasScript := false
+	for j := 0; j < len(args); j++ {
+		arg := args[j]
+		switch {
+		case arg == "-e" || arg == "-f" || arg == "--expression" || arg == "--file":
+			hasScript = true
+			script = append(script, arg)
+			if j+1 < len(args) {
+				script = append(script, args[j+1])
+				j++
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			script = append(script, arg)
+		case !hasScript:
+			hasScript = true
+			script = append(script, arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+
+	sed := simpleCommand{prefix: c.prefix, program: c.program, args: script}
+	switch len(files) {
+	case 0:
+		return "", false
+	case 1:
+		sed.args = append(sed.args, files[0])
+		return fmt.Sprintf("%s | diff -u %s -", sed, files[0]), true
+	default:
+		sed.args = append(sed.args, `"$f"`)
+		return fmt.Sprintf(`for f in %s; do %s | diff -u "$f" -; done`, strings.Join(files, " "), sed), true
+	}
+}
+
+// isExec reports whether the find argument runs a command for each file.
+func isExec(arg string) bool {
+	return arg == "-exec" || arg == "-execdir" || arg == "-ok" || arg == "-okdir"
+}
+
+// inPlaceFlag finds the sed in-place flag (-i, -i.bak, --in-place),
+// possibly combined with other short flags (-Ei, -ni.bak). Returns
+// the index of the argument with the flag and the argument without it
+// (empty if nothing is left), or -1 if there is no in-place flag.
+func inPlaceFlag(args []string) (int, string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return -1, ""
+		case arg == "--in-place" || strings.HasPrefix(arg, "--in-place="):
+			return i, ""
+		case arg == "--expression" || arg == "--file" || arg == "--line-length":
+			// Skip the option value.
+			i++
+		case !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") || arg == "-":
+			continue
+		default:
+		flags:
+			for j := 1; j < len(arg); j++ {
+				switch arg[j] {
+				case 'i':
+					// The rest of the argument is the backup suffix.
+					if j == 1 {
+						return i, ""
+					}
+					return i, arg[:j]
+				case 'e', 'f', 'l':
+					// The rest of the argument (or the next one)
+					// is the option value.
+					if j == len(arg)-1 {
+						i++
+					}
+					break flags
+				}
+			}
+		}
+	}
+	return -1, ""
+}
+
+// hasFlag reports whether the arguments contain the short flag
+// (possibly combined with others, as in -rf) or the long flag.
+func hasFlag(args []string, short rune, long string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if long != "" && arg == long {
+			return true
+		}
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsRune(arg[1:], short) {
+			return true
+		}
+	}
+	return false
+}
+
+// positional returns the arguments that are not flags.
+func positional(args []string) []string {
+	var result []string
+	flags := true
+	for _, arg := range args {
+		if flags && arg == "--" {
+			flags = false
+			continue
+		}
+		if flags && strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// askPreview asks the AI for a read-only variant of the command.
+// Returns an error if the suggested variant modifies files as well.
+func askPreview(ask ai.AskFunc, cmd string) (string, error) {
+	messages := []ai.Message{{Role: roleUser, Content: fmt.Sprintf(previewPrompt, cmd)}}
+	answer, err := ask(context.Background(), messages)
+	if err != nil {
+		return "", err
+	}
+	preview, _, _ := strings.Cut(strings.TrimSpace(removeFences(answer)), "\n")
+	preview = strings.TrimSpace(preview)
+	if preview == "" || preview == cmd || isDestructive(preview) {
+		return "", fmt.Errorf("the preview is not read-only: %s", preview)
+	}
+	return preview, nil
+}
+
+// confirmRun shows what the destructive command would change
+// and asks whether to run it. Reports whether to run the command.
+// Without a terminal, there is no one to ask, so the command runs as is.
+func confirmRun(out io.Writer, editor *lineEditor, ask ai.AskFunc, cmd string) (bool, error) {
+	if editor == nil || editor.raw == nil || !isDestructive(cmd) || checkRedacted(cmd) != nil {
+		return true, nil
+	}
+
+	preview, ok := previewCommand(cmd)
+	if !ok && ask != nil {
+		var err error
+		preview, err = askPreview(ask, cmd)
+		if err != nil {
+			fprintln(out, "WARNING:", err)
+		}
+	}
+
+	switch {
+	case preview == "":
+		fprintln(out, italic("The command modifies files, and there is no preview."))
+	case ok:
+		fprintln(out, italic("The command modifies files. Preview:"))
+		fprintln(out, highlight(preview))
+		fprintln(out)
+		fprintln(out, previewOutput(preview))
+	default:
+		// The AI might get the preview wrong, so don't run it
+		// until the user checks that it's read-only.
+		fprintln(out, italic("The command modifies files. Suggested preview (check that it's read-only):"))
+		fprintln(out, highlight(preview))
+		fprintln(out)
+		yes, err := askYes(editor, "Run the preview? [y]es [n]o ")
+		if err != nil {
+			return false, err
+		}
+		if yes {
+			fprintln(out, previewOutput(preview))
+		}
+	}
+	fprintln(out)
+	return askYes(editor, "Run the command? [y]es [n]o ")
+}
+
+// askYes asks the yes/no question and reports whether the answer is yes.
+// Ctrl-C or Ctrl-D mean no.
+func askYes(editor *lineEditor, prompt string) (bool, error) {
+	key, err := editor.readChoice(italic(prompt))
+	if errors.Is(err, errInterrupted) || err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return unicode.ToLower(key) == 'y', nil
+}
+
+// previewOutput runs the read-only preview command and returns its output.
+// Unlike execCommand, keeps the output even if the command fails,
+// since diff exits with 1 when the files differ.
+func previewOutput(preview string) string {
+	limits := loadRunLimits()
+	cmd, err := shellCommand(limits.wrap(preview))
+	if err != nil {
+		return err.Error()
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = runProcess(cmd, limits.timeout)
+	result := strings.TrimRight(output.String(), "\n")
+	if result == "" && err != nil {
+		return err.Error()
+	}
+	if result == "" {
+		return "(nothing would change)"
+	}
+	if len(result) > maxPreviewSize {
+		result = truncateBytes(result, maxPreviewSize) + "\n... (truncated)"
+	}
+	return result
+}
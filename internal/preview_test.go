@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_isDestructive(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"ls -l", false},
+		{"rm file.txt", true},
+		{"sudo rm -rf /tmp/build", true},
+		{"cd build && mv a.txt b.txt", true},
+		{"find . -name '*.tmp' | xargs rm", true},
+		{"find . -name '*.tmp' -delete", true},
+		{"find . -name '*.tmp' -exec rm {} \\;", true},
+		{"find . -name '*.go' -exec grep -l TODO {} +", false},
+		{"sed -i 's/foo/bar/' file.txt", true},
+		{"sed -i.bak 's/foo/bar/' file.txt", true},
+		{"sed -Ei 's/a/b/' f.txt", true},
+		{"sed -ri 's/a/b/' f.txt", true},
+		{"sed -n -i.bak 's/a/b/p' f.txt", true},
+		{"sed --in-place 's/a/b/' f.txt", true},
+		{"sed -e 's/a/b/' -i f.txt", true},
+		{"sed -E 's/a/b/' f.txt", false},
+		{"sed -f -i f.txt", false},
+		{"sed -es/i/x/ f.txt", false},
+		{"sed 's/foo/bar/' file.txt", false},
+		{"chmod -R 755 dir", true},
+		{"chmod 755 script.sh", false},
+		{"rename 's/.txt/.md/' *.txt", true},
+		{"echo rm", false},
+		{"# rm everything", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			be.Equal(t, isDestructive(tt.cmd), tt.want)
+		})
+	}
+}
+
+func Test_previewCommand(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"rm a.txt b.txt", "ls -ld -- a.txt b.txt"},
+		{"rm -rf build", "find build"},
+		{"sudo rm -R -- -dir", "sudo find -dir"},
+		{"mv -f old.txt new.txt", "ls -ld -- old.txt new.txt"},
+		{"find . -name '*.tmp' -delete", "find . -name '*.tmp' -print"},
+		{"sed -i 's/a|b/c/' file.txt", "sed 's/a|b/c/' file.txt | diff -u file.txt -"},
+		{"sed -i.bak -e 's/a/b/' -e 's/c/d/' a.txt b.txt", `for f in a.txt b.txt; do sed -e 's/a/b/' -e 's/c/d/' "$f" | diff -u "$f" -; done`},
+		{"sed -Ei 's/a+/b/' file.txt", "sed -E 's/a+/b/' file.txt | diff -u file.txt -"},
+		{"sed -ni.bak 's/a/b/p' file.txt", "sed -n 's/a/b/p' file.txt | diff -u file.txt -"},
+		{"chmod -R 755 dir", "find dir"},
+		{"rename 's/.txt/.md/' *.txt", "rename -n 's/.txt/.md/' *.txt"},
+		{"rm -rf $HOME/tmp", "find $HOME/tmp"},
+		{`rm -rf "$HOME"/tmp`, `find "$HOME"/tmp`},
+		{"mv ${SRC}/a.txt /tmp/b.txt", "ls -ld -- ${SRC}/a.txt /tmp/b.txt"},
+		{"sed -i 's/a/b/' $DIR/x.conf", "sed 's/a/b/' $DIR/x.conf | diff -u $DIR/x.conf -"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			got, ok := previewCommand(tt.cmd)
+			be.True(t, ok)
+			be.Equal(t, got, tt.want)
+		})
+	}
+
+	t.Run("no rule", func(t *testing.T) {
+		for _, cmd := range []string{
+			"find . -name '*.tmp' | xargs rm",
+			"rm -f $(cat files.txt)",
+			"find . -exec rm {} \\;",
+			"sed -i 's/a/b/'",
+			"rm",
+		} {
+			_, ok := previewCommand(cmd)
+			be.Equal(t, ok, false)
+		}
+	})
+}
+
+func Test_askPreview(t *testing.T) {
+	t.Run("read-only", func(t *testing.T) {
+		var sent []ai.Message
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			sent = history
+			return "```sh\nfind . -name '*.tmp'\n```\n\nLists the files.", nil
+		}
+		got, err := askPreview(ask, "find . -name '*.tmp' | xargs rm")
+		be.Err(t, err, nil)
+		be.Equal(t, got, "find . -name '*.tmp'")
+		be.Equal(t, len(sent), 1)
+		be.True(t, strings.Contains(sent[0].Content, "`find . -name '*.tmp' | xargs rm`"))
+	})
+	t.Run("destructive", func(t *testing.T) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			return "rm -i file.txt", nil
+		}
+		_, err := askPreview(ask, "rm file.txt")
+		be.Err(t, err, "the preview is not read-only: rm -i file.txt")
+	})
+	t.Run("error", func(t *testing.T) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			return "", errors.New("failed")
+		}
+		_, err := askPreview(ask, "rm file.txt")
+		be.Err(t, err, "failed")
+	})
+}
+
+func Test_runConfirmed(t *testing.T) {
+	defer setColor(false)()
+
+	t.Run("cancel", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		be.Err(t, os.WriteFile(path, []byte("hello\n"), 0644), nil)
+		editor := rawEditor("n")
+		err := runConfirmed(editor.out, editor, nil, &History{}, runSource{}, "rm "+path)
+		be.Err(t, err, nil)
+		got := editor.out.(*bytes.Buffer).String()
+		be.True(t, strings.Contains(got, "The command modifies files. Preview:\nls -ld -- "+path+"\n\n-rw-r--r--"))
+		be.True(t, strings.HasSuffix(got, "Run the command? [y]es [n]o \r\nCancelled\n"))
+		_, err = os.Stat(path)
+		be.Err(t, err, nil)
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		be.Err(t, os.WriteFile(path, []byte("foo\n"), 0644), nil)
+		editor := rawEditor("y")
+		err := runConfirmed(editor.out, editor, nil, &History{}, runSource{}, "sed -i s/foo/bar/ "+path)
+		be.Err(t, err, nil)
+		got := editor.out.(*bytes.Buffer).String()
+		be.True(t, strings.Contains(got, "-foo\n+bar"))
+		data, err := os.ReadFile(path)
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "bar\n")
+	})
+
+	t.Run("no preview", func(t *testing.T) {
+		editor := rawEditor("n")
+		err := runConfirmed(editor.out, editor, nil, &History{}, runSource{}, "ls | xargs rm")
+		be.Err(t, err, nil)
+		got := editor.out.(*bytes.Buffer).String()
+		be.True(t, strings.HasPrefix(got, "The command modifies files, and there is no preview.\n"))
+	})
+
+	t.Run("ai preview", func(t *testing.T) {
+		dir := t.TempDir()
+		marker := filepath.Join(dir, "marker")
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			return "touch " + marker, nil
+		}
+		editor := rawEditor("nn")
+		err := runConfirmed(editor.out, editor, ask, &History{}, runSource{}, "ls | xargs rm")
+		be.Err(t, err, nil)
+		got := editor.out.(*bytes.Buffer).String()
+		be.True(t, strings.HasPrefix(got, "The command modifies files. Suggested preview (check that it's read-only):\ntouch "))
+		be.True(t, strings.Contains(got, "Run the preview? [y]es [n]o \r\n\nRun the command?"))
+		// The preview does not run without confirmation.
+		_, err = os.Stat(marker)
+		be.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("ai preview confirmed", func(t *testing.T) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			return "echo would remove a.txt", nil
+		}
+		editor := rawEditor("yn")
+		err := runConfirmed(editor.out, editor, ask, &History{}, runSource{}, "ls | xargs rm")
+		be.Err(t, err, nil)
+		got := editor.out.(*bytes.Buffer).String()
+		be.True(t, strings.Contains(got, "Run the preview? [y]es [n]o \r\nwould remove a.txt\n\nRun the command?"))
+	})
+
+	t.Run("not a terminal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		be.Err(t, os.WriteFile(path, []byte("hello\n"), 0644), nil)
+		out := &bytes.Buffer{}
+		editor := newLineEditor(strings.NewReader(""), out)
+		err := runConfirmed(out, editor, nil, &History{}, runSource{}, "rm "+path)
+		be.Err(t, err, nil)
+		_, err = os.Stat(path)
+		be.True(t, os.IsNotExist(err))
+	})
+}
+
+func Test_previewOutput(t *testing.T) {
+	be.Equal(t, previewOutput("true"), "(nothing would change)")
+	// diff exits with 1 when the files differ, but the output matters.
+	be.Equal(t, previewOutput("echo changed; exit 1"), "changed")
+	be.Equal(t, previewOutput("exit 2"), "exit status 2")
+}
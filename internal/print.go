@@ -3,14 +3,20 @@ package internal
 import (
 	"fmt"
 	"io"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/nalgeon/howto/internal/ai"
 )
 
+// color reports whether to use colors and styles in the output.
+// Disabled with the NO_COLOR environment variable or on dumb terminals.
+var color = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+
 // PrintUsage prints usage information.
 func PrintUsage(out io.Writer) {
-	fprintln(out, "Usage: howto [-h] [-v] [-run] [question]")
+	fprintln(out, "Usage: howto [-h] [-v] [-run] [-f file]... [-s session] [question]")
 	fprintln(out)
 	fprintln(out, "A humble command-line assistant.")
 	fprintln(out, "See", underlined("https://github.com/nalgeon/howto"), "for details.")
@@ -18,9 +24,32 @@ func PrintUsage(out io.Writer) {
 	fprintln(out, "Options:")
 	fprintln(out, "  -h, --help      Show this help message and exit")
 	fprintln(out, "  -v, --version   Show version information and exit")
-	fprintln(out, "  -run            Run the last suggested command")
+	fprintln(out, "  -i              Start an interactive session")
+	fprintln(out, "  -run [id]       Run the last suggested command (or the one from the log)")
+	fprintln(out, "  -copy           Copy the last suggested command to the clipboard")
+	fprintln(out, "  -undo           Remove the last question and answer")
+	fprintln(out, "  -retry [temp]   Ask the last question again (with the given temperature)")
+	fprintln(out, "  -branch turn [session]")
+	fprintln(out, "                  Copy the conversation up to the turn to a new session")
+	fprintln(out, "  -plan task      Suggest a multi-step plan and run it step by step")
+	fprintln(out, "  -o file         Save the plan as a shell script (with -plan)")
+	fprintln(out, "  -f file         Attach the file as context (can be repeated)")
+	fprintln(out, "  -s session      Use the named session (switch to it if no question)")
+	fprintln(out, "  --no-cache      Ask the AI even if the answer is cached or recalled")
+	fprintln(out, "  --no-menu       Don't show the action menu after the answer")
+	fprintln(out, "  --no-stdin      Ignore the piped input (e.g. in a while read loop)")
+	fprintln(out, "  --sandbox       Run commands with no network and read-only files (Linux)")
+	fprintln(out, "  -sessions       List sessions")
+	fprintln(out, "  -show [session] Show the conversation in the session")
+	fprintln(out, "  -delete session Delete the session")
+	fprintln(out, "  -export format [session]")
+	fprintln(out, "                  Export the conversation as md, json or sh")
+	fprintln(out, "  -clear-cache    Remove all cached answers")
+	fprintln(out, "  -log [n]        Show the last n questions and answers from the log")
+	fprintln(out, "  -search text    Search the log for past answers")
 	fprintln(out, "  question        Describe the task to get a command suggestion")
 	fprintln(out, "                  Use '+' to ask a follow up question")
+	fprintln(out, "                  Pipe text to howto to use it as context")
 }
 
 // printVersion prints version, configuration, and history information.
@@ -38,39 +67,71 @@ func printVersion(out io.Writer, ver Version, config ai.Config, history *History
 	fprintln(out, "- Model:", config.Model)
 	fprintln(out, "- Temperature:", config.Temperature)
 	fprintln(out, "- Timeout:", config.Timeout)
+	fprintln(out, "- Max tokens:", config.MaxTokens, "("+config.ContextStrategy+")")
+	if config.EmbedModel != "" {
+		fprintln(out, "- Embedding model:", config.EmbedModel)
+	}
+	if config.CacheTTL > 0 {
+		fprintln(out, "- Cache:", config.CacheTTL, fmt.Sprintf("(max %d MB)", config.CacheSize/1024/1024))
+	} else {
+		fprintln(out, "- Cache: (disabled)")
+	}
 	fprintln(out)
 	fprintln(out, bold("## Prompt"))
-	printWrapped(out, config.Prompt, 80)
+	printWrapped(out, config.Prompt, terminalWidth())
 	fprintln(out)
-	fprintln(out, bold("## History"))
+	fprintln(out, bold("## History"), "("+history.sessionName()+")")
 	history.Print(out)
 }
 
+// printConversation prints the full conversation history:
+// each question followed by the answer.
+func printConversation(out io.Writer, history *History) {
+	if len(history.messages) == 0 {
+		fprintln(out, "(empty)")
+		return
+	}
+	var turn int
+	for i, msg := range history.messages {
+		if msg.Role == roleUser {
+			if i > 0 {
+				fprintln(out)
+			}
+			turn++
+			printWrapped(out, bold(fmt.Sprintf("🧑 [%d] %s", turn, msg.Content)), terminalWidth())
+			fprintln(out)
+		} else {
+			printAnswer(out, msg.Content)
+			if msg.Run != nil {
+				printRun(out, msg.Run)
+			}
+		}
+	}
+}
+
+// printRun prints the result of running the suggested command.
+func printRun(out io.Writer, run *runResult) {
+	fprintln(out)
+	fprintln(out, italic(fmt.Sprintf("Ran %s (exit code %d, took %s)",
+		run.Command, run.ExitCode, run.Duration.Round(time.Millisecond))))
+	if run.Error != "" {
+		fprintln(out, italic(run.Error))
+	}
+	for _, output := range []string{run.Stdout, run.Stderr} {
+		if output != "" {
+			printWrapped(out, output, terminalWidth())
+		}
+	}
+}
+
 // printWrapped prints a string (can be multiple lines)
 // to stdout, hard-wrapping each line at the specified width.
 func printWrapped(out io.Writer, s string, width int) {
 	lines := strings.Split(s, "\n")
 	for _, line := range lines {
-		words := strings.Split(line, " ")
-		var text strings.Builder
-		var lineLen int
-
-		for _, word := range words {
-			wordLen := len(word)
-			if lineLen == 0 {
-				text.WriteString(word)
-				lineLen += wordLen
-			} else if lineLen+wordLen+1 <= width {
-				text.WriteString(" ")
-				text.WriteString(word)
-				lineLen += wordLen + 1
-			} else {
-				text.WriteString("\n")
-				text.WriteString(word)
-				lineLen = wordLen
-			}
+		for _, wrapped := range wrap(line, width) {
+			fprintln(out, strings.ReplaceAll(wrapped, nbsp, " "))
 		}
-		fprintln(out, text.String())
 	}
 }
 
@@ -79,9 +140,22 @@ func fprintln(out io.Writer, args ...any) {
 }
 
 func bold(s string) string {
+	if !color {
+		return s
+	}
 	return "\033[1m" + s + "\033[0m"
 }
 
+func italic(s string) string {
+	if !color {
+		return s
+	}
+	return "\033[3m" + s + "\033[0m"
+}
+
 func underlined(s string) string {
+	if !color {
+		return s
+	}
 	return "\033[4m" + s + "\033[0m"
 }
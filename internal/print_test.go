@@ -14,7 +14,7 @@ func TestPrintUsage(t *testing.T) {
 	out := &bytes.Buffer{}
 	PrintUsage(out)
 	got := out.String()
-	be.True(t, strings.Contains(got, "Usage: howto [-h] [-v] [-run] [question]"))
+	be.True(t, strings.Contains(got, "Usage: howto [-h] [-v] [-run] [-f file]... [-s session] [question]"))
 }
 
 func Test_printVersion(t *testing.T) {
@@ -27,7 +27,7 @@ func Test_printVersion(t *testing.T) {
 		Temperature: 0.5,
 		Timeout:     10 * time.Second,
 	}
-	history := &History{messages: []string{"q1", "a1"}}
+	history := &History{messages: conversation("q1", "a1")}
 
 	out := &bytes.Buffer{}
 	ver := NewVersion("1.2.3", "commit", "now")
@@ -89,7 +89,33 @@ func Test_printWrapped(t *testing.T) {
 }
 
 func Test_bold(t *testing.T) {
-	got := bold("test")
-	want := "\033[1mtest\033[0m"
-	be.Equal(t, got, want)
+	t.Run("color", func(t *testing.T) {
+		defer setColor(true)()
+		got := bold("test")
+		want := "\033[1mtest\033[0m"
+		be.Equal(t, got, want)
+	})
+	t.Run("no color", func(t *testing.T) {
+		defer setColor(false)()
+		got := bold("test")
+		be.Equal(t, got, "test")
+	})
+}
+
+// setColor enables or disables colors
+// and returns a function that restores the previous setting.
+func setColor(enabled bool) func() {
+	old := color
+	color = enabled
+	return func() { color = old }
+}
+
+func Test_printWrapped_markdown(t *testing.T) {
+	defer setColor(true)()
+	out := &bytes.Buffer{}
+	printWrapped(out, renderMarkdown("- Run `curl -I example.org` to **fetch** headers"), 20)
+	want := "• Run\n" +
+		"  \033[1mcurl\033[0m \033[36m-I\033[0m example.org\n" +
+		"  to \033[1mfetch\033[0m headers\n"
+	be.Equal(t, out.String(), want)
 }
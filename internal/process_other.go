@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package internal
+
+import "os/exec"
+
+// setProcessGroup does nothing: process groups are not supported.
+func setProcessGroup(cmd *exec.Cmd) func() {
+	return func() {}
+}
+
+// terminateGroup kills the command: there is no graceful way to stop it.
+func terminateGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killGroup kills the command.
+func killGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
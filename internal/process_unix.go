@@ -0,0 +1,75 @@
+//go:build linux || darwin
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// setProcessGroup makes the command the leader of a new process group,
+// so that it can be stopped along with the processes it starts.
+// If howto runs in the foreground of a terminal, the new group
+// takes over the terminal, so that the command can still read from it
+// (e.g. a sudo password prompt). Returns the function that gives
+// the terminal back to howto once the command is done.
+func setProcessGroup(cmd *exec.Cmd) func() {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	fd := int(os.Stdin.Fd())
+	pgrp, err := foregroundGroup(fd)
+	if err != nil || pgrp != syscall.Getpgrp() {
+		// Not a terminal, or howto runs in the background.
+		return func() {}
+	}
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = fd
+	return func() {
+		// howto is in the background now, so changing
+		// the foreground group would stop it with SIGTTOU.
+		signal.Ignore(syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTOU)
+		_ = setForegroundGroup(fd, pgrp)
+	}
+}
+
+// foregroundGroup returns the foreground process group of the terminal.
+func foregroundGroup(fd int) (int, error) {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd),
+		uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+// setForegroundGroup makes the process group the foreground one in the terminal.
+func setForegroundGroup(fd int, pgrp int) error {
+	id := int32(pgrp)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd),
+		uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&id)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// terminateGroup asks the command's process group to exit.
+// Also wakes up the stopped processes, so that they get the signal.
+func terminateGroup(cmd *exec.Cmd) error {
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGCONT)
+	return err
+}
+
+// killGroup kills the command's process group.
+func killGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// Name of the file containing the embeddings of the archived questions.
+const indexFileName = "howto-index.jsonl"
+
+// Minimum cosine similarity of the questions
+// to consider them the same.
+const recallThreshold = 0.9
+
+// indexRecord is a single line in the index file:
+// the embedding of the question from the archive entry.
+type indexRecord struct {
+	ID    int    `json:"id"`
+	Model string `json:"model"`
+	// Little-endian float32 values encoded in base64.
+	Vector string `json:"vector"`
+}
+
+// embedQuestion returns the embedding of the question, so that
+// it can be recalled and indexed. Recall is best effort: errors
+// are printed as warnings and never fail the question.
+func embedQuestion(out io.Writer, embed ai.EmbedFunc, question string) []float32 {
+	vector, err := embed(question)
+	if err != nil {
+		fprintln(out, "WARNING: recall:", err)
+		return nil
+	}
+	return vector
+}
+
+// recallSimilar looks up a question similar to the one with
+// the given embedding in the archive, and if found, prints
+// the stored answer and returns the archive entry.
+func recallSimilar(out io.Writer, dir string, vector []float32) (archiveEntry, bool) {
+	id, score, err := findSimilar(dir, ai.Conf.EmbedModel, vector)
+	if err != nil {
+		fprintln(out, "WARNING: recall:", err)
+		return archiveEntry{}, false
+	}
+	if score < recallThreshold {
+		return archiveEntry{}, false
+	}
+
+	entries, err := readArchive(dir)
+	if err != nil {
+		fprintln(out, "WARNING: recall:", err)
+		return archiveEntry{}, false
+	}
+	entry, err := findEntry(entries, fmt.Sprint(id))
+	if err != nil {
+		// The index is ahead of the archive, ignore it.
+		return archiveEntry{}, false
+	}
+
+	fprintln(out, italic(fmt.Sprintf("You asked something similar before (#%d): %s", entry.id, entry.title())))
+	fprintln(out)
+	printAnswer(out, entry.answer)
+	fprintln(out)
+	fprintln(out, italic("Use 'howto -run' to run it, or 'howto --no-cache ...' to ask anyway."))
+	return entry, true
+}
+
+// recalledAnswer returns the answer from the archive entry
+// as a history message. Keeps the original time and archive ID,
+// so that the runs are recorded in the original entry.
+func recalledAnswer(entry archiveEntry) message {
+	return message{
+		Role:    roleAssistant,
+		Content: entry.answer,
+		Time:    entry.time,
+		Vendor:  entry.vendor,
+		Model:   entry.model,
+		ID:      entry.id,
+	}
+}
+
+// indexQuestion adds the embedding of the question
+// from the archive entry with the given ID to the index.
+func indexQuestion(dir string, id int, model string, vector []float32) error {
+	if dir == "" || id == 0 || len(vector) == 0 {
+		return nil
+	}
+	rec := indexRecord{ID: id, Model: model, Vector: encodeVector(vector)}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, indexFileName)
+	err = withLock(path, func() error {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("index question: %w", err)
+	}
+	return nil
+}
+
+// findSimilar returns the ID of the archive entry with the question
+// most similar to the given embedding, along with the cosine similarity.
+// Only considers the embeddings created with the same model.
+func findSimilar(dir string, model string, vector []float32) (int, float64, error) {
+	path := filepath.Join(dir, indexFileName)
+	var bestID int
+	var bestScore float64
+	err := withLock(path, func() error {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				var rec indexRecord
+				if json.Unmarshal(line, &rec) == nil && rec.Model == model {
+					score := cosine(vector, decodeVector(rec.Vector))
+					if score > bestScore {
+						bestID, bestScore = rec.ID, score
+					}
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("read index: %w", err)
+	}
+	return bestID, bestScore, nil
+}
+
+// cosine returns the cosine similarity of the vectors,
+// or 0 if they have different dimensions.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// encodeVector encodes the vector as base64
+// of little-endian float32 values.
+func encodeVector(vector []float32) string {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// decodeVector decodes the vector encoded with encodeVector.
+// Returns nil if the encoding is invalid.
+func decodeVector(s string) []float32 {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(buf)%4 != 0 {
+		return nil
+	}
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_cosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same", []float32{1, 2, 3}, []float32{1, 2, 3}, 1},
+		{"scaled", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"different length", []float32{1, 0}, []float32{1, 0, 0}, 0},
+		{"zero", []float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cosine(tt.a, tt.b)
+			be.True(t, got > tt.want-1e-6 && got < tt.want+1e-6)
+		})
+	}
+}
+
+func Test_encodeVector(t *testing.T) {
+	vector := []float32{0.1, -2.5, 3e-8, 0}
+	be.Equal(t, decodeVector(encodeVector(vector)), vector)
+	be.Equal(t, decodeVector("not base64!"), nil)
+}
+
+func Test_findSimilar(t *testing.T) {
+	dir := t.TempDir()
+	be.Err(t, indexQuestion(dir, 1, "model", []float32{1, 0, 0}), nil)
+	be.Err(t, indexQuestion(dir, 2, "model", []float32{0, 1, 0}), nil)
+	be.Err(t, indexQuestion(dir, 3, "other", []float32{0, 0, 1}), nil)
+
+	id, score, err := findSimilar(dir, "model", []float32{0.1, 1, 0})
+	be.Err(t, err, nil)
+	be.Equal(t, id, 2)
+	be.True(t, score > 0.99)
+
+	// Embeddings of other models are ignored.
+	id, score, err = findSimilar(dir, "model", []float32{0, 0, 1})
+	be.Err(t, err, nil)
+	be.Equal(t, id, 0)
+	be.Equal(t, score, 0.0)
+
+	// Empty index.
+	id, _, err = findSimilar(t.TempDir(), "model", []float32{1, 0, 0})
+	be.Err(t, err, nil)
+	be.Equal(t, id, 0)
+}
+
+func TestHowto_recall(t *testing.T) {
+	defer setColor(false)()
+	ver := NewVersion("1.2.3", "commit", "now")
+	var calls int
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		calls++
+		return "tar -xzf a.tgz -C dir\n\nExtracts into dir.", nil
+	}
+	vectors := map[string][]float32{
+		"untar into a directory":         {1, 0.1, 0},
+		"how to untar into a directory?": {1, 0.12, 0},
+		"list files":                     {0, 0, 1},
+		"show disk usage":                {0, 1, 0},
+		"disk usage?":                    {0, 1, 0.01},
+	}
+	embed := func(text string) ([]float32, error) {
+		if vector, ok := vectors[text]; ok {
+			return vector, nil
+		}
+		return nil, errors.New("embedding failed")
+	}
+	history, err := loadSession(t.TempDir(), "", defaultSession)
+	be.Err(t, err, nil)
+	out := &bytes.Buffer{}
+
+	err = Howto(nil, out, ask, embed, ver, []string{"untar", "into", "a", "directory"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 1)
+
+	// A similar question is answered from the archive.
+	out.Reset()
+	err = Howto(nil, out, ask, embed, ver, []string{"how", "to", "untar", "into", "a", "directory?"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 1)
+	be.True(t, strings.HasPrefix(out.String(), "You asked something similar before (#1): untar into a directory\n"))
+	be.True(t, strings.Contains(out.String(), "tar -xzf a.tgz -C dir\n"))
+	be.True(t, strings.Contains(out.String(), "howto -run"))
+	be.Equal(t, history.LastCommand(), "tar -xzf a.tgz -C dir")
+
+	// An unrelated question goes to the AI.
+	err = Howto(nil, out, ask, embed, ver, []string{"list", "files"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 2)
+
+	// Skip the recall.
+	err = Howto(nil, out, ask, embed, ver, []string{"--no-cache", "how", "to", "untar", "into", "a", "directory?"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 3)
+
+	// Questions asked without the cache are still indexed.
+	err = Howto(nil, out, ask, embed, ver, []string{"--no-cache", "show", "disk", "usage"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 4)
+	err = Howto(nil, out, ask, embed, ver, []string{"disk", "usage?"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 4)
+
+	// Embedding errors do not fail the question.
+	out.Reset()
+	err = Howto(nil, out, ask, embed, ver, []string{"something", "else"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, calls, 5)
+	be.True(t, strings.HasPrefix(out.String(), "WARNING: recall: embedding failed\n"))
+}
+
+func TestHowto_recall_run(t *testing.T) {
+	defer setColor(false)()
+	ver := NewVersion("1.2.3", "commit", "now")
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		question := history[len(history)-1].Content
+		return "echo " + strings.Fields(question)[0] + "\n\nPrints it.", nil
+	}
+	vectors := map[string][]float32{
+		"first question":  {1, 0},
+		"second question": {0, 1},
+		"first one":       {1, 0.01},
+	}
+	embed := func(text string) ([]float32, error) {
+		return vectors[text], nil
+	}
+	dir := t.TempDir()
+	history, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+	out := &bytes.Buffer{}
+
+	err = Howto(nil, out, ask, embed, ver, []string{"first", "question"}, history)
+	be.Err(t, err, nil)
+	err = Howto(nil, out, ask, embed, ver, []string{"second", "question"}, history)
+	be.Err(t, err, nil)
+
+	// The recalled answer replaces the current conversation.
+	err = Howto(nil, out, ask, embed, ver, []string{"first", "one"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, contents(history.messages), []string{"first one", "echo first\n\nPrints it."})
+
+	out.Reset()
+	err = Howto(nil, out, ask, embed, ver, []string{"-run"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, out.String(), "echo first\n\nfirst\n")
+
+	// The run is recorded in the original archive entry.
+	entries, err := readArchive(dir)
+	be.Err(t, err, nil)
+	be.Equal(t, entries[0].runs, 1)
+	be.Equal(t, entries[1].runs, 0)
+}
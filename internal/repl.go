@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// errCancelled is returned when the user cancels the request with Ctrl-C.
+var errCancelled = errors.New("cancelled")
+
+// Prompt to ask for a detailed explanation of the command.
+const explainPrompt = "Explain the command `%s` in detail: what each part and option does, and what can go wrong."
+
+// Prompt to ask for a different command for the same task.
+const alternativesPrompt = "Suggest a different command for the same task."
+
+// replCommands lists the REPL slash commands with their descriptions.
+var replCommands = []struct{ name, desc string }{
+	{"/run", "Run the last suggested command"},
+	{"/edit", "Edit the last suggested command and run it"},
+	{"/copy", "Copy the last suggested command to the clipboard"},
+	{"/explain", "Explain the last suggested command in detail"},
+	{"/alternatives", "Suggest a different command for the same task"},
+	{"/new", "Start a new conversation"},
+	{"/model [name]", "Show or change the AI model"},
+	{"/help", "Show this help"},
+	{"/quit", "Exit (or press Ctrl-D)"},
+}
+
+// repl runs the interactive loop: each line is a question that
+// continues the conversation, or a slash command. Saves the history
+// after each answer, so other howto processes see the changes.
+// Caches the answers unless noCache is set.
+func repl(in io.Reader, out io.Writer, ask ai.AskFunc, history *History, noCache bool) error {
+	editor := newLineEditor(in, out)
+	if !noCache {
+		ask = withCache(ask, history)
+	}
+	fprintln(out, italic("Ask a question, or type /help for commands. Press Ctrl-D to exit."))
+
+	for {
+		line, err := editor.readLine(bold("howto> "), "")
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err == io.EOF {
+			fprintln(out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var quit bool
+		if strings.HasPrefix(line, "/") {
+			quit, err = replCommand(out, editor, &ask, history, noCache, line)
+		} else {
+			err = replAnswer(out, ask, history, line)
+		}
+		if err != nil {
+			fprintln(out, "ERROR:", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// replCommand executes the REPL slash command.
+// Reports whether the user wants to quit.
+func replCommand(out io.Writer, editor *lineEditor, ask *ai.AskFunc, history *History, noCache bool, line string) (bool, error) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "/run":
+		return false, runCommand(out, editor, *ask, history)
+	case "/edit":
+		command := history.LastCommand()
+		if command == "" {
+			return false, fmt.Errorf("no command to edit")
+		}
+		edited, err := editor.readLine(bold("edit> "), command)
+		if errors.Is(err, errInterrupted) || err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		edited = strings.TrimSpace(edited)
+		if edited == "" {
+			return false, nil
+		}
+		src, _ := lastSource(history)
+		src.edited = true
+		return false, runConfirmed(out, editor, *ask, history, src, edited)
+	case "/copy":
+		return false, copyCommand(out, history)
+	case "/explain":
+		command := history.LastCommand()
+		if command == "" {
+			return false, fmt.Errorf("no command to explain")
+		}
+		return false, replAnswer(out, *ask, history, fmt.Sprintf(explainPrompt, command))
+	case "/alternatives":
+		if history.LastCommand() == "" {
+			return false, fmt.Errorf("no command to replace")
+		}
+		return false, replAnswer(out, *ask, history, alternativesPrompt)
+	case "/new":
+		history.Clear()
+		fprintln(out, "Started a new conversation")
+		return false, history.Save()
+	case "/model":
+		if arg == "" {
+			fprintln(out, "Model:", ai.Conf.Model)
+			return false, nil
+		}
+		config := ai.Conf
+		config.Model = arg
+		newAsk, err := ai.New(config)
+		if err != nil {
+			return false, err
+		}
+		ai.Conf = config
+		if !noCache {
+			newAsk = withCache(newAsk, history)
+		}
+		*ask = newAsk
+		fprintln(out, "Switched to model", arg)
+		return false, nil
+	case "/help":
+		for _, c := range replCommands {
+			fprintln(out, fmt.Sprintf("  %-15s %s", c.name, c.desc))
+		}
+		return false, nil
+	case "/quit", "/exit":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command: %s (type /help for commands)", cmd)
+	}
+}
+
+// replAnswer asks the question as a follow-up to the conversation,
+// prints the answer and saves the history. Ctrl-C cancels the request.
+func replAnswer(out io.Writer, ask ai.AskFunc, history *History, question string) error {
+	err := answer(out, cancellable(ask), nil, false, "+"+question, nil, history)
+	if err != nil {
+		// Remove the unanswered question.
+		if i := lastQuestion(history.messages); i == len(history.messages)-1 {
+			history.messages = history.messages[:i]
+		}
+		return err
+	}
+	return history.Save()
+}
+
+// cancellable wraps the ask function so that Ctrl-C cancels
+// the request (discarding the answer) instead of exiting.
+func cancellable(ask ai.AskFunc) ai.AskFunc {
+	return func(ctx context.Context, history []ai.Message) (string, error) {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		answer, err := ask(ctx, history)
+		if ctx.Err() != nil {
+			return "", errCancelled
+		}
+		return answer, err
+	}
+}
+
+// withCache wraps the ask function with the answer cache,
+// unless the history is transient.
+func withCache(ask ai.AskFunc, history *History) ai.AskFunc {
+	if history.dir == "" {
+		return ask
+	}
+	return ai.NewCache(filepath.Join(history.dir, cacheDirName), ai.Conf).Wrap(ask)
+}
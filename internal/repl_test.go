@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_repl(t *testing.T) {
+	defer setColor(false)()
+	var sent [][]ai.Message
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		sent = append(sent, history)
+		question := history[len(history)-1].Content
+		if question == "fail" {
+			return "", errors.New("failed")
+		}
+		return "echo " + strings.ReplaceAll(question, " ", "-") + "\n\nPrints it.", nil
+	}
+
+	t.Run("conversation", func(t *testing.T) {
+		sent = nil
+		dir := t.TempDir()
+		history, err := loadSession(dir, "", defaultSession)
+		be.Err(t, err, nil)
+		history.messages = conversation("old", "echo old")
+
+		in := strings.NewReader("first\nsecond\n/run\n")
+		out := &bytes.Buffer{}
+		err = repl(in, out, ask, history, false)
+		be.Err(t, err, nil)
+
+		// Each question continues the conversation.
+		be.Equal(t, len(sent), 2)
+		be.Equal(t, len(sent[1]), 5)
+		be.Equal(t, contents(history.messages)[2:], []string{"first", "echo first\n\nPrints it.", "second", "echo second\n\nPrints it."})
+		be.True(t, strings.HasSuffix(out.String(), "howto> echo second\n\nsecond\nhowto> \n"))
+
+		// The history is saved after each answer.
+		saved, err := loadSession(dir, "", defaultSession)
+		be.Err(t, err, nil)
+		be.Equal(t, len(saved.messages), 6)
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		sent = nil
+		clipboard := fakeClipboard(t)
+		history := &History{messages: conversation("list", "ls -l")}
+		in := strings.NewReader("/copy\n/explain\n/new\n/model\n/help\n/unknown\n/quit\nignored\n")
+		out := &bytes.Buffer{}
+		err := repl(in, out, ask, history, false)
+		be.Err(t, err, nil)
+
+		got := out.String()
+		be.Equal(t, readClipboard(t, clipboard), "ls -l")
+		be.True(t, strings.Contains(got, "Copied to clipboard\n"))
+		be.Equal(t, len(sent), 1)
+		be.Equal(t, sent[0][len(sent[0])-1].Content, "Explain the command `ls -l` in detail: what each part and option does, and what can go wrong.")
+		be.True(t, strings.Contains(got, "Started a new conversation\n"))
+		be.True(t, strings.Contains(got, "Model: "+ai.Conf.Model+"\n"))
+		be.True(t, strings.Contains(got, "  /run            Run the last suggested command\n"))
+		be.True(t, strings.Contains(got, "ERROR: unknown command: /unknown (type /help for commands)\n"))
+		be.Equal(t, len(history.messages), 0)
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		dir := t.TempDir()
+		history, err := loadSession(dir, "", defaultSession)
+		be.Err(t, err, nil)
+		in := strings.NewReader("hello\n/edit\necho bye\n")
+		out := &bytes.Buffer{}
+		err = repl(in, out, ask, history, false)
+		be.Err(t, err, nil)
+		be.True(t, strings.Contains(out.String(), "(was: echo hello) echo bye\n\nbye\n"))
+
+		// The edited command is not the suggested one,
+		// so it's recorded as edited and not archived.
+		msg, _ := history.lastAnswer()
+		be.True(t, msg.Run.Edited)
+		entries, err := readArchive(dir)
+		be.Err(t, err, nil)
+		be.Equal(t, entries[0].runs, 0)
+		records := readAudit(t, dir)
+		be.Equal(t, records[0].Command, "echo bye")
+		be.True(t, records[0].Edited)
+	})
+
+	t.Run("no cache", func(t *testing.T) {
+		for _, noCache := range []bool{false, true} {
+			sent = nil
+			dir := t.TempDir()
+			history, err := loadSession(dir, "", defaultSession)
+			be.Err(t, err, nil)
+			in := strings.NewReader("first\n/new\nfirst\n")
+			out := &bytes.Buffer{}
+			err = repl(in, out, ask, history, noCache)
+			be.Err(t, err, nil)
+			// The repeated question is answered from the cache, if it's on.
+			if noCache {
+				be.Equal(t, len(sent), 2)
+			} else {
+				be.Equal(t, len(sent), 1)
+			}
+			_, err = os.Stat(filepath.Join(dir, cacheDirName))
+			be.Equal(t, os.IsNotExist(err), noCache)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		history := &History{messages: conversation("list", "ls -l")}
+		in := strings.NewReader("fail\n")
+		out := &bytes.Buffer{}
+		err := repl(in, out, ask, history, false)
+		be.Err(t, err, nil)
+		be.True(t, strings.Contains(out.String(), "ERROR: failed\n"))
+		// The unanswered question is removed.
+		be.Equal(t, contents(history.messages), []string{"list", "ls -l"})
+	})
+}
+
+func Test_cancellable(t *testing.T) {
+	t.Run("answer", func(t *testing.T) {
+		ask := func(_ context.Context, history []ai.Message) (string, error) {
+			return "answer", nil
+		}
+		got, err := cancellable(ask)(context.Background(), nil)
+		be.Err(t, err, nil)
+		be.Equal(t, got, "answer")
+	})
+	t.Run("interrupt", func(t *testing.T) {
+		// The request is cancelled, so nothing gets cached.
+		var calls int
+		cache := ai.NewCache(t.TempDir(), ai.Config{CacheTTL: time.Hour, CacheSize: 1024})
+		ask := cache.Wrap(func(ctx context.Context, history []ai.Message) (string, error) {
+			calls++
+			<-ctx.Done()
+			return "", ctx.Err()
+		})
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			p, _ := os.FindProcess(os.Getpid())
+			_ = p.Signal(os.Interrupt)
+		}()
+		messages := []ai.Message{{Role: roleUser, Content: "list files"}}
+		_, err := cancellable(ask)(context.Background(), messages)
+		be.Err(t, err, errCancelled)
+
+		// Not cached, so the second request goes to the AI as well.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = ask(ctx, messages)
+		be.Err(t, err, context.Canceled)
+		be.Equal(t, calls, 2)
+	})
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sandbox is true if the commands run in the sandbox:
+// read-only file system except the current directory,
+// no network and no privileges.
+var sandbox = sandboxEnabled()
+
+// Exit code and error prefix of the sandbox init process
+// when it fails to set up the sandbox.
+const (
+	sandboxExitCode  = 125
+	sandboxErrPrefix = "howto sandbox: "
+)
+
+// sandboxEnabled reports whether the sandbox is enabled
+// in the settings (HOWTO_SANDBOX).
+func sandboxEnabled() bool {
+	switch strings.ToLower(os.Getenv("HOWTO_SANDBOX")) {
+	case "on", "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+// shellCommand returns the command that runs the shell command line,
+// in the sandbox if it's enabled.
+func shellCommand(command string) (*exec.Cmd, error) {
+	if sandbox {
+		return sandboxCommand(command)
+	}
+	// Use the shell to execute the command and avoid parsing the arguments.
+	return exec.Command("sh", "-c", command), nil
+}
+
+// sandboxError returns the error if the command failed
+// because the sandbox could not be set up, or nil otherwise.
+func sandboxError(exitCode int, stderr string) error {
+	if !sandbox || exitCode != sandboxExitCode {
+		return nil
+	}
+	msg, ok := strings.CutPrefix(strings.TrimSpace(stderr), sandboxErrPrefix)
+	if !ok {
+		return nil
+	}
+	return fmt.Errorf("can't create the sandbox: %s", msg)
+}
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Environment variable that marks the howto process
+// started as the sandbox init.
+const sandboxInitEnv = "_HOWTO_SANDBOX_INIT"
+
+// Linux constants missing from the syscall package.
+const (
+	capSysAdmin          = 21
+	prCapBSetDrop        = 24
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	capabilityVersion3   = 0x20080522
+)
+
+// Mount flags that can't be cleared inside a user namespace.
+const lockedMountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// Explains the most common reason the sandbox fails.
+const userNamespaceHint = "unprivileged user namespaces may be disabled " +
+	"(see the kernel.unprivileged_userns_clone and user.max_user_namespaces sysctls)"
+
+func init() {
+	if os.Getenv(sandboxInitEnv) != "" {
+		sandboxInit()
+	}
+}
+
+// sandboxCommand returns the command that runs the shell command line
+// in the sandbox. Starts howto itself in new user, mount and network
+// namespaces as the sandbox init, which sets up the file system,
+// drops the privileges and runs the command.
+func sandboxCommand(command string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("can't create the sandbox: %w", err)
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	cmd := exec.Command(self, command)
+	cmd.Env = append(os.Environ(), sandboxInitEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		// The init needs to mount, the command does not.
+		AmbientCaps: []uintptr{capSysAdmin},
+	}
+	return cmd, nil
+}
+
+// sandboxStartError explains why the sandbox process failed to start.
+func sandboxStartError(err error) error {
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("can't create the sandbox: %w, %s", err, userNamespaceHint)
+	}
+	return fmt.Errorf("can't create the sandbox: %w", err)
+}
+
+// sandboxInit sets up the sandbox and replaces the process
+// with the shell running the command. Never returns.
+func sandboxInit() {
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, sandboxErrPrefix+err.Error())
+		os.Exit(sandboxExitCode)
+	}
+	if len(os.Args) < 2 {
+		fail(errors.New("missing command"))
+	}
+	if err := setupSandbox(); err != nil {
+		fail(err)
+	}
+	if err := dropPrivileges(); err != nil {
+		fail(err)
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		fail(err)
+	}
+	env := make([]string, 0, len(os.Environ()))
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, sandboxInitEnv+"=") {
+			env = append(env, v)
+		}
+	}
+	err = syscall.Exec(sh, []string{"sh", "-c", os.Args[1]}, env)
+	fail(err)
+}
+
+// setupSandbox makes the whole file system read-only,
+// except the current directory.
+func setupSandbox() error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if cwd == "/" {
+		return errors.New("can't run in the root directory, it would be writable")
+	}
+
+	// Keep the mount changes inside the namespace.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("%w, %s", err, userNamespaceHint)
+	}
+	// Mount the current directory onto itself so it stays writable
+	// when its parent mount becomes read-only.
+	if err := syscall.Mount(cwd, cwd, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", cwd, err)
+	}
+
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, path := range mounts {
+		if path == cwd || strings.HasPrefix(path, cwd+"/") {
+			continue
+		}
+		if err := remountReadOnly(path); err != nil {
+			return err
+		}
+	}
+
+	// Step into the new mount: the working directory
+	// still points to the directory under it.
+	return os.Chdir(cwd)
+}
+
+// mountPoints returns the mount points of the current mount namespace.
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, unescapeMountPath(fields[4]))
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (like \040 for space)
+// in the mountinfo path.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// remountReadOnly makes the mount read-only, keeping its other flags
+// (the kernel does not allow clearing them inside a user namespace).
+func remountReadOnly(path string) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOENT) {
+			// Can't get there, so can't write there either.
+			return nil
+		}
+		return fmt.Errorf("make %s read-only: %w", path, err)
+	}
+	// The statfs flags have the same values as the mount flags.
+	flags := uintptr(stat.Flags) & lockedMountFlags
+	err := syscall.Mount("", path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|flags, "")
+	if err != nil {
+		return fmt.Errorf("make %s read-only: %w", path, err)
+	}
+	return nil
+}
+
+// dropPrivileges makes sure the command can't regain
+// the capabilities of the sandbox init.
+func dropPrivileges() error {
+	if os.Getuid() == 0 {
+		// Root gets all the capabilities from the bounding set
+		// on exec, so empty it.
+		for c := uintptr(0); ; c++ {
+			_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapBSetDrop, c, 0)
+			if errno == syscall.EINVAL {
+				break
+			}
+			if errno != 0 {
+				return fmt.Errorf("drop capabilities: %w", errno)
+			}
+		}
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0); errno != 0 {
+		return fmt.Errorf("clear capabilities: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs: %w", errno)
+	}
+	// Clear the permitted, effective and inheritable sets.
+	// Otherwise root keeps the inheritable capabilities on exec.
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: capabilityVersion3}
+	var data [2]struct{ effective, permitted, inheritable uint32 }
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("clear capabilities: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_execCommand_sandbox(t *testing.T) {
+	old := sandbox
+	sandbox = true
+	defer func() { sandbox = old }()
+
+	// The test binary runs as the sandbox init.
+	_, _, err := execCommand("true")
+	if err != nil && strings.HasPrefix(err.Error(), "can't create the sandbox") {
+		t.Skip(err)
+	}
+	be.Err(t, err, nil)
+
+	t.Run("current directory", func(t *testing.T) {
+		output, code, err := execCommand("echo hello > sandbox.txt && cat sandbox.txt && rm sandbox.txt")
+		be.Err(t, err, nil)
+		be.Equal(t, code, 0)
+		be.Equal(t, output.stdout, "hello")
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		path := filepath.Join(os.TempDir(), "howto-sandbox.txt")
+		_, code, err := execCommand("echo hello > " + path)
+		be.True(t, err != nil)
+		be.True(t, strings.Contains(err.Error(), "Read-only file system"))
+		be.Equal(t, code, 2)
+		_, err = os.Stat(path)
+		be.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("no network", func(t *testing.T) {
+		output, _, err := execCommand("cat /proc/net/dev")
+		be.Err(t, err, nil)
+		be.True(t, strings.Contains(output.stdout, "lo:"))
+		be.Equal(t, strings.Count(output.stdout, ":"), 1)
+	})
+
+	t.Run("no privileges", func(t *testing.T) {
+		output, _, err := execCommand("grep CapEff /proc/self/status")
+		be.Err(t, err, nil)
+		be.Equal(t, output.stdout, "CapEff:\t0000000000000000")
+	})
+}
+
+func Test_unescapeMountPath(t *testing.T) {
+	be.Equal(t, unescapeMountPath("/mnt/data"), "/mnt/data")
+	be.Equal(t, unescapeMountPath(`/mnt/my\040disk`), "/mnt/my disk")
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// sandboxCommand returns an error: the sandbox relies on Linux namespaces.
+func sandboxCommand(command string) (*exec.Cmd, error) {
+	return nil, errors.New("the sandbox is only supported on Linux")
+}
+
+// sandboxStartError explains why the sandbox process failed to start.
+func sandboxStartError(err error) error {
+	return fmt.Errorf("can't create the sandbox: %w", err)
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_sandboxEnabled(t *testing.T) {
+	t.Setenv("HOWTO_SANDBOX", "")
+	be.Equal(t, sandboxEnabled(), false)
+	t.Setenv("HOWTO_SANDBOX", "on")
+	be.Equal(t, sandboxEnabled(), true)
+}
+
+func Test_sandboxError(t *testing.T) {
+	old := sandbox
+	defer func() { sandbox = old }()
+
+	sandbox = true
+	err := sandboxError(sandboxExitCode, sandboxErrPrefix+"operation not permitted\n")
+	be.Err(t, err, "can't create the sandbox: operation not permitted")
+	be.Err(t, sandboxError(sandboxExitCode, "command failed"), nil)
+	be.Err(t, sandboxError(1, sandboxErrPrefix+"operation not permitted"), nil)
+
+	sandbox = false
+	be.Err(t, sandboxError(sandboxExitCode, sandboxErrPrefix+"operation not permitted"), nil)
+}
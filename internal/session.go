@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Name of the default session.
+const defaultSession = "default"
+
+// Name of the file containing the current session name.
+const sessionFileName = "howto-session"
+
+// Name of the directory containing the named sessions.
+const sessionDirName = "sessions"
+
+// sessionRe describes a valid session name.
+var sessionRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+
+// errNoSessions is returned when sessions are not available
+// (the history is transient).
+var errNoSessions = errors.New("sessions are not available")
+
+// loadSession loads the conversation history of the named session.
+// The default session is specific to the terminal.
+func loadSession(dir, terminal, name string) (*History, error) {
+	if dir == "" {
+		return nil, errNoSessions
+	}
+	path, err := sessionPath(dir, terminal, name)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	hist, err := loadHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	hist.dir = dir
+	hist.terminal = terminal
+	if name != defaultSession {
+		hist.session = name
+	}
+	return hist, nil
+}
+
+// switchSession loads the named session and makes it current
+// in the terminal.
+func switchSession(dir, terminal, name string) (*History, error) {
+	hist, err := loadSession(dir, terminal, name)
+	if err != nil {
+		return nil, err
+	}
+	err = setCurrentSession(dir, terminal, name)
+	if err != nil {
+		return nil, err
+	}
+	return hist, nil
+}
+
+// sessionPath returns the path to the history file of the named session.
+// The default session is specific to the terminal, with a fallback
+// to the global history if the terminal is unknown.
+func sessionPath(dir, terminal, name string) (string, error) {
+	if name == defaultSession && terminal == "" {
+		return filepath.Join(dir, fileName), nil
+	}
+	if name == defaultSession {
+		return filepath.Join(dir, terminalDirName, terminal+".json"), nil
+	}
+	if !sessionRe.MatchString(name) {
+		return "", fmt.Errorf("invalid session name: %s", name)
+	}
+	return filepath.Join(dir, sessionDirName, name+".json"), nil
+}
+
+// currentSessionPath returns the path to the file containing
+// the name of the current session in the terminal.
+func currentSessionPath(dir, terminal string) string {
+	if terminal == "" {
+		return filepath.Join(dir, sessionFileName)
+	}
+	return filepath.Join(dir, terminalDirName, terminal+".session")
+}
+
+// currentSession returns the name of the current session in the terminal.
+func currentSession(dir, terminal string) string {
+	data, err := os.ReadFile(currentSessionPath(dir, terminal))
+	if err != nil {
+		return defaultSession
+	}
+	name := strings.TrimSpace(string(data))
+	if !sessionRe.MatchString(name) {
+		return defaultSession
+	}
+	return name
+}
+
+// setCurrentSession makes the named session current in the terminal.
+func setCurrentSession(dir, terminal, name string) error {
+	path := currentSessionPath(dir, terminal)
+	if name == defaultSession {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(name), 0600)
+}
+
+// listSessions returns the names of all sessions,
+// starting with the default one.
+func listSessions(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, errNoSessions
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, sessionDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && !entry.IsDir() && sessionRe.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return append([]string{defaultSession}, names...), nil
+}
+
+// deleteSession deletes the named session. If the session is current,
+// switches to the default one. The default session itself is cleared.
+func deleteSession(dir, terminal, name string) error {
+	if dir == "" {
+		return errNoSessions
+	}
+	path, err := sessionPath(dir, terminal, name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) && name != defaultSession {
+		return fmt.Errorf("session not found: %s", name)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if currentSession(dir, terminal) == name {
+		return setCurrentSession(dir, terminal, defaultSession)
+	}
+	return nil
+}
+
+// printSessions prints the list of sessions,
+// marking the current one with an asterisk.
+func printSessions(out io.Writer, history *History) error {
+	names, err := listSessions(history.dir)
+	if err != nil {
+		return err
+	}
+	current := currentSession(history.dir, history.terminal)
+	for _, name := range names {
+		hist, err := loadSession(history.dir, history.terminal, name)
+		if err != nil {
+			return err
+		}
+		var count int
+		for _, msg := range hist.messages {
+			if msg.Role == roleUser {
+				count++
+			}
+		}
+		noun := "questions"
+		if count == 1 {
+			noun = "question"
+		}
+		mark := " "
+		if name == current {
+			mark = "*"
+		}
+		fprintln(out, mark, name, fmt.Sprintf("(%d %s)", count, noun))
+	}
+	return nil
+}
+
+// showSession prints the conversation of the named session,
+// or the current one if the name is empty.
+func showSession(out io.Writer, history *History, name string) error {
+	if name == "" || name == history.sessionName() {
+		printConversation(out, history)
+		return nil
+	}
+	hist, err := loadExistingSession(history, name)
+	if err != nil {
+		return err
+	}
+	printConversation(out, hist)
+	return nil
+}
+
+// loadExistingSession loads the named session in the same
+// terminal as the given history. Fails if the session does not exist.
+func loadExistingSession(history *History, name string) (*History, error) {
+	if history.dir == "" {
+		return nil, errNoSessions
+	}
+	path, err := sessionPath(history.dir, history.terminal, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("session not found: %s", name)
+	}
+	return loadSession(history.dir, history.terminal, name)
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_loadSession(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		dir := t.TempDir()
+		hist, err := loadSession(dir, "", defaultSession)
+		be.Err(t, err, nil)
+		be.Equal(t, hist.path, filepath.Join(dir, fileName))
+		be.Equal(t, hist.sessionName(), defaultSession)
+	})
+
+	t.Run("named", func(t *testing.T) {
+		dir := t.TempDir()
+		hist, err := loadSession(dir, "", "deploy")
+		be.Err(t, err, nil)
+		be.Equal(t, hist.path, filepath.Join(dir, sessionDirName, "deploy.json"))
+		be.Equal(t, hist.sessionName(), "deploy")
+
+		hist.Add(newQuestion("q1"))
+		hist.Add(newAnswer("a1"))
+		err = hist.Save()
+		be.Err(t, err, nil)
+
+		hist, err = loadSession(dir, "", "deploy")
+		be.Err(t, err, nil)
+		be.Equal(t, contents(hist.messages), []string{"q1", "a1"})
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := loadSession(dir, "", "../etc")
+		be.Err(t, err, "invalid session name")
+	})
+
+	t.Run("transient", func(t *testing.T) {
+		_, err := loadSession("", "", "deploy")
+		be.Err(t, err, errNoSessions)
+	})
+}
+
+func Test_switchSession(t *testing.T) {
+	dir := t.TempDir()
+	be.Equal(t, currentSession(dir, ""), defaultSession)
+
+	_, err := switchSession(dir, "", "deploy")
+	be.Err(t, err, nil)
+	be.Equal(t, currentSession(dir, ""), "deploy")
+
+	_, err = switchSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+	be.Equal(t, currentSession(dir, ""), defaultSession)
+}
+
+func Test_listSessions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ffmpeg", "deploy"} {
+		hist, err := loadSession(dir, "", name)
+		be.Err(t, err, nil)
+		err = hist.Save()
+		be.Err(t, err, nil)
+	}
+	names, err := listSessions(dir)
+	be.Err(t, err, nil)
+	be.Equal(t, names, []string{defaultSession, "deploy", "ffmpeg"})
+}
+
+func Test_deleteSession(t *testing.T) {
+	t.Run("current", func(t *testing.T) {
+		dir := t.TempDir()
+		hist, err := switchSession(dir, "", "deploy")
+		be.Err(t, err, nil)
+		err = hist.Save()
+		be.Err(t, err, nil)
+
+		err = deleteSession(dir, "", "deploy")
+		be.Err(t, err, nil)
+		be.Equal(t, currentSession(dir, ""), defaultSession)
+		_, err = os.Stat(hist.path)
+		be.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		dir := t.TempDir()
+		err := deleteSession(dir, "", "deploy")
+		be.Err(t, err, "session not found: deploy")
+	})
+
+	t.Run("default", func(t *testing.T) {
+		dir := t.TempDir()
+		err := deleteSession(dir, "", defaultSession)
+		be.Err(t, err, nil)
+	})
+}
+
+func TestHowto_sessions(t *testing.T) {
+	ver := NewVersion("1.2.3", "commit", "now")
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		return "answer to " + history[len(history)-1].Content, nil
+	}
+	dir := t.TempDir()
+	history, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+
+	// Ask in the named session without switching to it.
+	out := &bytes.Buffer{}
+	err = Howto(nil, out, ask, nil, ver, []string{"-s", "ffmpeg", "convert", "video"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, currentSession(dir, ""), defaultSession)
+
+	// Ask an unrelated question in the default session.
+	err = Howto(nil, out, ask, nil, ver, []string{"what", "is", "my", "ip"}, history)
+	be.Err(t, err, nil)
+
+	// The named session is still there.
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-show", "ffmpeg"}, history)
+	be.Err(t, err, nil)
+	be.True(t, bytes.Contains(out.Bytes(), []byte("answer to convert video")))
+
+	// Switch to the named session.
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-s", "ffmpeg"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, out.String(), "Switched to session ffmpeg\n")
+	be.Equal(t, currentSession(dir, ""), "ffmpeg")
+
+	// List the sessions.
+	out.Reset()
+	err = Howto(nil, out, ask, nil, ver, []string{"-sessions"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, out.String(), "  default (1 question)\n* ffmpeg (1 question)\n")
+
+	// Delete the named session.
+	err = Howto(nil, out, ask, nil, ver, []string{"-delete", "ffmpeg"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, currentSession(dir, ""), defaultSession)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package internal
+
+import "errors"
+
+// writeSyslog returns an error: there is no local system log.
+func writeSyslog(msg string) error {
+	return errors.New("the system log is only supported on Linux and macOS")
+}
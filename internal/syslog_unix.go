@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package internal
+
+import "log/syslog"
+
+// writeSyslog sends the message to the local system log
+// (syslog or journald) with the howto tag.
+func writeSyslog(msg string) error {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_USER, "howto")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Notice(msg)
+}
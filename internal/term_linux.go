@@ -0,0 +1,9 @@
+package internal
+
+import "syscall"
+
+// ioctl requests to get and set the terminal attributes.
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
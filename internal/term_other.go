@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// getTermWidth returns the width of the terminal attached to the file.
+// Not supported on this platform, so always returns 0.
+func getTermWidth(f *os.File) int {
+	return 0
+}
+
+// makeRaw puts the terminal into raw mode.
+// Not supported on this platform, so always fails.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw mode is not supported")
+}
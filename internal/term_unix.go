@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package internal
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the terminal window size as reported by the TIOCGWINSZ ioctl.
+type winsize struct {
+	rows   uint16
+	cols   uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+// getTermWidth returns the width of the terminal attached to the file,
+// or 0 if the file is not a terminal.
+func getTermWidth(f *os.File) int {
+	var ws winsize
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL, f.Fd(),
+		uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)),
+	)
+	if errno != 0 {
+		return 0
+	}
+	return int(ws.cols)
+}
+
+// makeRaw puts the terminal attached to the file into raw mode
+// (no echo, no line buffering, no signals on Ctrl-C) and returns
+// the function that restores the previous mode.
+func makeRaw(f *os.File) (func(), error) {
+	var old syscall.Termios
+	err := termios(f, ioctlGetTermios, &old)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = termios(f, ioctlSetTermios, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { _ = termios(f, ioctlSetTermios, &old) }, nil
+}
+
+// termios gets or sets the terminal attributes.
+func termios(f *os.File, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
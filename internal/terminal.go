@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the directory containing the per-terminal histories.
+const terminalDirName = "terminals"
+
+// Terminal histories not used for this long are removed.
+const terminalTTL = 7 * 24 * time.Hour
+
+// Special HOWTO_SESSION value to use the global history.
+const globalTerminal = "global"
+
+// unsafeKeyRe matches characters not allowed in terminal keys.
+var unsafeKeyRe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// terminalKey returns the key that identifies the current terminal,
+// so that each terminal has its own conversation history.
+// Uses (in order of priority):
+//   - the HOWTO_SESSION environment variable,
+//   - the tmux pane (TMUX_PANE),
+//   - the terminal device name and the session ID (Linux only),
+//   - the parent shell process ID.
+//
+// Returns an empty string if howto is not attached to a terminal
+// (e.g. runs from a script) or if HOWTO_SESSION=global.
+// In this case, the global history is used.
+func terminalKey() string {
+	if key := os.Getenv("HOWTO_SESSION"); key != "" {
+		if key == globalTerminal {
+			return ""
+		}
+		return "env-" + sanitizeKey(key)
+	}
+	if pane := os.Getenv("TMUX_PANE"); pane != "" {
+		return "tmux-" + sanitizeKey(pane)
+	}
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	for _, f := range files {
+		if !isTerminal(f) {
+			continue
+		}
+		if name := ttyName(f); name != "" {
+			// The kernel reuses the device names right away,
+			// so tell the terminals apart by the login session.
+			key := "tty-" + sanitizeKey(strings.TrimPrefix(name, "/dev/"))
+			if sid := ttySession(); sid != "" {
+				key += "-" + sid
+			}
+			return key
+		}
+		return "pid-" + strconv.Itoa(os.Getppid())
+	}
+	return ""
+}
+
+// ttyName returns the name of the terminal device attached
+// to the file, or an empty string if it's unknown.
+func ttyName(f *os.File) string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	link := "/proc/self/fd/" + strconv.Itoa(int(f.Fd()))
+	name, err := os.Readlink(link)
+	if err != nil || !strings.HasPrefix(name, "/dev/") {
+		return ""
+	}
+	return name
+}
+
+// ttySession returns the ID of the session the process belongs to
+// (the shell started by the terminal), or an empty string if it's unknown.
+func ttySession() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return ""
+	}
+	// pid (comm) state ppid pgrp session ...
+	// The command name may contain spaces and parentheses.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return ""
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 4 {
+		return ""
+	}
+	return fields[3]
+}
+
+// sanitizeKey makes the key safe to use as a file name.
+// If the key has unsafe characters, adds its hash, so that
+// different keys (like a/b and a-b) stay different.
+func sanitizeKey(key string) string {
+	safe := strings.Trim(unsafeKeyRe.ReplaceAllString(key, "-"), "-.")
+	if safe == key {
+		return key
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return fmt.Sprintf("%s-%08x", safe, h.Sum32())
+}
+
+// cleanupTerminals removes the terminal histories
+// that have not been used for a long time, along with their lock files.
+// Never removes a lock file by itself: its modification time does not
+// change when it's used, and another process may be holding the lock.
+func cleanupTerminals(dir string, now time.Time) {
+	tdir := filepath.Join(dir, terminalDirName)
+	entries, err := os.ReadDir(tdir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() || strings.HasSuffix(entry.Name(), ".lock") {
+			continue
+		}
+		if now.Sub(info.ModTime()) > terminalTTL {
+			path := filepath.Join(tdir, entry.Name())
+			_ = os.Remove(path)
+			_ = os.Remove(path + ".lock")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_terminalKey(t *testing.T) {
+	t.Run("explicit", func(t *testing.T) {
+		t.Setenv("HOWTO_SESSION", "my project")
+		be.Equal(t, terminalKey(), "env-my-project-585f2c02")
+	})
+
+	t.Run("global", func(t *testing.T) {
+		t.Setenv("HOWTO_SESSION", globalTerminal)
+		t.Setenv("TMUX_PANE", "%3")
+		be.Equal(t, terminalKey(), "")
+	})
+
+	t.Run("tmux", func(t *testing.T) {
+		t.Setenv("HOWTO_SESSION", "")
+		t.Setenv("TMUX_PANE", "%3")
+		be.Equal(t, terminalKey(), "tmux-3-06b96c69")
+	})
+}
+
+func Test_sanitizeKey(t *testing.T) {
+	be.Equal(t, sanitizeKey("pts/3"), "pts-3-dba4fcf8")
+	be.Equal(t, sanitizeKey("../../etc"), "etc-cb7d5d3f")
+	be.Equal(t, sanitizeKey("work_1.2"), "work_1.2")
+	// Different keys stay different.
+	be.Equal(t, sanitizeKey("a-b"), "a-b")
+	be.Equal(t, sanitizeKey("a/b"), "a-b-3a8e75c1")
+}
+
+func Test_ttySession(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+	// The session ID is the process ID of the session leader.
+	sid := ttySession()
+	n, err := strconv.Atoi(sid)
+	be.Err(t, err, nil)
+	be.True(t, n > 0)
+}
+
+func Test_cleanupTerminals(t *testing.T) {
+	dir := t.TempDir()
+	tdir := filepath.Join(dir, terminalDirName)
+	err := os.MkdirAll(tdir, 0700)
+	be.Err(t, err, nil)
+
+	now := time.Now()
+	fresh := filepath.Join(tdir, "tty-pts-1.json")
+	stale := filepath.Join(tdir, "tty-pts-2.json")
+	for _, path := range []string{fresh, fresh + ".lock", stale, stale + ".lock"} {
+		err = os.WriteFile(path, []byte("[]"), 0600)
+		be.Err(t, err, nil)
+	}
+	old := now.Add(-terminalTTL - time.Hour)
+	// The lock files are old even when the histories are in use.
+	for _, path := range []string{stale, fresh + ".lock", stale + ".lock"} {
+		err = os.Chtimes(path, old, old)
+		be.Err(t, err, nil)
+	}
+
+	cleanupTerminals(dir, now)
+
+	_, err = os.Stat(fresh)
+	be.Err(t, err, nil)
+	_, err = os.Stat(fresh + ".lock")
+	be.Err(t, err, nil)
+	_, err = os.Stat(stale)
+	be.True(t, os.IsNotExist(err))
+	_, err = os.Stat(stale + ".lock")
+	be.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+// lastQuestion returns the index of the last question
+// in the conversation, or -1 if there are no questions.
+func lastQuestion(messages []message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == roleUser {
+			return i
+		}
+	}
+	return -1
+}
+
+// undo removes the last question and answer from the conversation,
+// so that the previous answer becomes the last one.
+func undo(out io.Writer, history *History) error {
+	i := lastQuestion(history.messages)
+	if i < 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	history.messages = history.messages[:i]
+
+	cmd := history.LastCommand()
+	if cmd == "" {
+		fprintln(out, "The conversation is empty")
+		return nil
+	}
+	fprintln(out, "Back to the previous suggestion:")
+	fprintln(out, highlight(cmd))
+	return nil
+}
+
+// retry asks the last question again, replacing the last answer.
+// Uses the given temperature (if any) instead of the configured one.
+func retry(out io.Writer, ask ai.AskFunc, history *History, temperature string) error {
+	i := lastQuestion(history.messages)
+	if i < 0 {
+		return fmt.Errorf("nothing to retry")
+	}
+
+	ctx := context.Background()
+	if temperature != "" {
+		temp, err := strconv.ParseFloat(temperature, 64)
+		if err != nil || temp < 0 || temp > 2 {
+			return fmt.Errorf("invalid temperature: %s (use 0-2)", temperature)
+		}
+		ctx = ai.WithTemperature(ctx, temp)
+	}
+	if ask == nil {
+		return fmt.Errorf("ask function is not set")
+	}
+
+	redactor, err := newRedactor(os.Getenv("HOWTO_REDACT"), history.messages)
+	if err != nil {
+		return err
+	}
+	question := history.messages[i]
+	history.messages = history.messages[:i+1]
+	answer, err := ask(ctx, history.chat())
+	if err != nil {
+		return err
+	}
+
+	answer = removeFences(answer)
+	printAnswer(out, redactor.restore(answer))
+	addAnswer(out, history, question, answer, nil)
+	return nil
+}
+
+// branch copies the conversation up to the given turn
+// (a question and its answer, starting from 1) to a new session
+// and switches to it. The argument is the turn number, optionally
+// followed by the session name. Returns the new session history.
+func branch(out io.Writer, history *History, arg string) (*History, error) {
+	turnStr, name, _ := strings.Cut(arg, " ")
+	name = strings.TrimSpace(name)
+	turn, err := strconv.Atoi(turnStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid turn: %s", turnStr)
+	}
+
+	// Find the end of the turn.
+	var turns, end int
+	for end < len(history.messages) {
+		if history.messages[end].Role == roleUser {
+			if turns == turn {
+				break
+			}
+			turns++
+		}
+		end++
+	}
+	if turn < 1 || turn > turns {
+		return nil, fmt.Errorf("no such turn: %d", turn)
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("%s-%d", history.sessionName(), turn)
+	}
+	if name == defaultSession {
+		return nil, fmt.Errorf("can't branch to the default session")
+	}
+	if history.dir == "" {
+		return nil, errNoSessions
+	}
+	path, err := sessionPath(history.dir, history.terminal, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("session already exists: %s", name)
+	}
+
+	branched, err := switchSession(history.dir, history.terminal, name)
+	if err != nil {
+		return nil, err
+	}
+	branched.messages = append([]message{}, history.messages[:end]...)
+	fprintln(out, "Switched to session", name, fmt.Sprintf("(branched at turn %d)", turn))
+	return branched, nil
+}
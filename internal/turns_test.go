@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/howto/internal/ai"
+)
+
+func Test_undo(t *testing.T) {
+	defer setColor(false)()
+
+	t.Run("previous answer", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("list files", "ls", "+ with details", "ls -l")}
+		err := undo(out, history)
+		be.Err(t, err, nil)
+		be.Equal(t, contents(history.messages), []string{"list files", "ls"})
+		be.Equal(t, history.LastCommand(), "ls")
+		be.Equal(t, out.String(), "Back to the previous suggestion:\nls\n")
+	})
+
+	t.Run("unanswered question", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("list files", "ls", "+ with details")}
+		err := undo(out, history)
+		be.Err(t, err, nil)
+		be.Equal(t, contents(history.messages), []string{"list files", "ls"})
+	})
+
+	t.Run("last question", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("list files", "ls")}
+		err := undo(out, history)
+		be.Err(t, err, nil)
+		be.Equal(t, len(history.messages), 0)
+		be.Equal(t, out.String(), "The conversation is empty\n")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		err := undo(&bytes.Buffer{}, &History{})
+		be.Err(t, err, "nothing to undo")
+	})
+}
+
+func Test_retry(t *testing.T) {
+	defer setColor(false)()
+	var sent []ai.Message
+	var temp float64
+	ask := func(ctx context.Context, history []ai.Message) (string, error) {
+		sent = history
+		temp, _ = ai.TemperatureFrom(ctx)
+		return "ls -la\n\nLists all files.", nil
+	}
+
+	t.Run("success", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		history := &History{messages: conversation("list files", "ls", "+ with details", "ls -l")}
+		err := retry(out, ask, history, "")
+		be.Err(t, err, nil)
+		be.Equal(t, len(sent), 3)
+		be.Equal(t, sent[2].Content, "+ with details")
+		be.Equal(t, contents(history.messages), []string{"list files", "ls", "+ with details", "ls -la\n\nLists all files."})
+		be.Equal(t, out.String(), "ls -la\n\nLists all files.\n")
+	})
+
+	t.Run("temperature", func(t *testing.T) {
+		history := &History{messages: conversation("list files", "ls")}
+		err := retry(&bytes.Buffer{}, ask, history, "1.5")
+		be.Err(t, err, nil)
+		be.Equal(t, temp, 1.5)
+		be.Equal(t, history.LastCommand(), "ls -la")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		err := retry(&bytes.Buffer{}, ask, &History{}, "")
+		be.Err(t, err, "nothing to retry")
+	})
+
+	t.Run("invalid temperature", func(t *testing.T) {
+		history := &History{messages: conversation("list files", "ls")}
+		err := retry(&bytes.Buffer{}, ask, history, "hot")
+		be.Err(t, err, "invalid temperature: hot (use 0-2)")
+		err = retry(&bytes.Buffer{}, ask, history, "3")
+		be.Err(t, err, "invalid temperature: 3 (use 0-2)")
+	})
+}
+
+func Test_branch(t *testing.T) {
+	dir := t.TempDir()
+	history, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+	history.messages = conversation("q1", "a1", "+ q2", "a2", "+ q3", "a3")
+
+	t.Run("default name", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		branched, err := branch(out, history, "2")
+		be.Err(t, err, nil)
+		be.Equal(t, branched.sessionName(), "default-2")
+		be.Equal(t, contents(branched.messages), []string{"q1", "a1", "+ q2", "a2"})
+		be.Equal(t, currentSession(dir, ""), "default-2")
+		be.Equal(t, out.String(), "Switched to session default-2 (branched at turn 2)\n")
+		// The original conversation is intact.
+		be.Equal(t, len(history.messages), 6)
+	})
+
+	t.Run("named", func(t *testing.T) {
+		branched, err := branch(&bytes.Buffer{}, history, "3 other")
+		be.Err(t, err, nil)
+		be.Equal(t, branched.sessionName(), "other")
+		be.Equal(t, len(branched.messages), 6)
+		be.Err(t, branched.Save(), nil)
+
+		_, err = branch(&bytes.Buffer{}, history, "1 other")
+		be.Err(t, err, "session already exists: other")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := branch(&bytes.Buffer{}, history, "first")
+		be.Err(t, err, "invalid turn: first")
+		_, err = branch(&bytes.Buffer{}, history, "0")
+		be.Err(t, err, "no such turn: 0")
+		_, err = branch(&bytes.Buffer{}, history, "4")
+		be.Err(t, err, "no such turn: 4")
+		_, err = branch(&bytes.Buffer{}, history, "1 default")
+		be.Err(t, err, "can't branch to the default session")
+		_, err = branch(&bytes.Buffer{}, &History{messages: history.messages}, "1")
+		be.Err(t, err, errNoSessions)
+	})
+}
+
+func TestHowto_turns(t *testing.T) {
+	defer setColor(false)()
+	ver := NewVersion("1.2.3", "commit", "now")
+	ask := func(_ context.Context, history []ai.Message) (string, error) {
+		return "echo " + history[len(history)-1].Content, nil
+	}
+	dir := t.TempDir()
+	history, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+	out := &bytes.Buffer{}
+
+	err = Howto(nil, out, ask, nil, ver, []string{"one"}, history)
+	be.Err(t, err, nil)
+	err = Howto(nil, out, ask, nil, ver, []string{"+", "two"}, history)
+	be.Err(t, err, nil)
+
+	err = Howto(nil, out, ask, nil, ver, []string{"-undo"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, history.LastCommand(), "echo one")
+
+	// The undo is saved.
+	saved, err := loadSession(dir, "", defaultSession)
+	be.Err(t, err, nil)
+	be.Equal(t, len(saved.messages), 2)
+
+	err = Howto(nil, out, ask, nil, ver, []string{"-retry"}, history)
+	be.Err(t, err, nil)
+	be.Equal(t, len(history.messages), 2)
+
+	err = Howto(nil, out, ask, nil, ver, []string{"-branch", "1", "fork"}, history)
+	be.Err(t, err, nil)
+	forked, err := loadSession(dir, "", "fork")
+	be.Err(t, err, nil)
+	be.Equal(t, contents(forked.messages), []string{"one", "echo one"})
+}
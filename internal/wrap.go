@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Default output width when the terminal width is unknown.
+const defaultWidth = 80
+
+// terminalWidth returns the width of the terminal attached to stdout.
+// Falls back to the COLUMNS environment variable,
+// and then to the default width.
+func terminalWidth() int {
+	if width := getTermWidth(os.Stdout); width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return defaultWidth
+}
+
+// wrap hard-wraps a single line at the specified display width.
+// Keeps the indentation (including list bullets) on continuation lines
+// and never breaks words, URLs or `inline commands`.
+func wrap(line string, width int) []string {
+	indent, rest := splitIndent(line)
+	words := splitWords(rest)
+	if len(words) == 0 {
+		return []string{strings.TrimRightFunc(line, unicode.IsSpace)}
+	}
+
+	var lines []string
+	var text strings.Builder
+	text.WriteString(indent)
+	lineLen := displayWidth(indent)
+	hanging := strings.Repeat(" ", lineLen)
+
+	for i, word := range words {
+		wordLen := displayWidth(word)
+		if i == 0 {
+			text.WriteString(word)
+			lineLen += wordLen
+		} else if lineLen+wordLen+1 <= width {
+			text.WriteString(" ")
+			text.WriteString(word)
+			lineLen += wordLen + 1
+		} else {
+			lines = append(lines, text.String())
+			text.Reset()
+			text.WriteString(hanging)
+			text.WriteString(word)
+			lineLen = len(hanging) + wordLen
+		}
+	}
+	lines = append(lines, text.String())
+	return lines
+}
+
+// splitIndent splits the line into the leading indentation
+// (whitespace followed by an optional list bullet) and the rest.
+func splitIndent(line string) (indent, rest string) {
+	rest = strings.TrimLeft(line, " \t")
+	indent = line[:len(line)-len(rest)]
+
+	// Unordered list bullets.
+	for _, bullet := range []string{"- ", "* ", "+ ", "• "} {
+		if strings.HasPrefix(rest, bullet) {
+			n := len(bullet)
+			return indent + rest[:n], rest[n:]
+		}
+	}
+
+	// Ordered list numbers, e.g. "1. " or "12) ".
+	n := 0
+	for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+		n++
+	}
+	if n > 0 && n+1 < len(rest) && (rest[n] == '.' || rest[n] == ')') && rest[n+1] == ' ' {
+		return indent + rest[:n+2], rest[n+2:]
+	}
+
+	return indent, rest
+}
+
+// splitWords splits the text into words separated by whitespace.
+// Text enclosed in backticks is kept as a single word even if it contains spaces.
+func splitWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	inCode := false
+	for _, r := range s {
+		switch {
+		case r == '`':
+			inCode = !inCode
+			word.WriteRune(r)
+		case (r == ' ' || r == '\t') && !inCode:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// truncate shortens the string to the specified display width,
+// replacing the cut off part with an ellipsis.
+// Never cuts a character in half.
+func truncate(s string, width int) string {
+	if displayWidth(s) <= width {
+		return s
+	}
+	const ellipsis = "..."
+	limit := width - len(ellipsis)
+	var n int
+	for i, r := range s {
+		w := runeWidth(r)
+		if n+w > limit {
+			return s[:i] + ellipsis
+		}
+		n += w
+	}
+	return s
+}
+
+// displayWidth returns the number of terminal columns
+// needed to display the string. Ignores terminal escape sequences.
+func displayWidth(s string) int {
+	var n int
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			i = skipEscape(s, i)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		n += runeWidth(r)
+		i += size
+	}
+	return n
+}
+
+// skipEscape returns the position right after the terminal
+// escape sequence starting at the given position.
+func skipEscape(s string, i int) int {
+	i++
+	if i >= len(s) || s[i] != '[' {
+		return i + 1
+	}
+	// Control sequence: parameters followed by a final byte.
+	for i++; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// runeWidth returns the number of terminal columns
+// needed to display the character: 0 for combining and control
+// characters, 2 for wide East Asian characters and emoji, 1 otherwise.
+func runeWidth(r rune) int {
+	switch {
+	case r == utf8.RuneError:
+		return 1
+	case r < 0x20 || (r >= 0x7f && r < 0xa0):
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case isWide(r):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// wideRanges lists the Unicode ranges of characters
+// that take two columns in the terminal.
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x231A, 0x231B},   // watch, hourglass
+	{0x2E80, 0x303E},   // CJK radicals, punctuation
+	{0x3041, 0x33FF},   // Hiragana, Katakana, CJK compatibility
+	{0x3400, 0x4DBF},   // CJK extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE30, 0xFE4F},   // CJK compatibility forms
+	{0xFF00, 0xFF60},   // fullwidth forms
+	{0xFFE0, 0xFFE6},   // fullwidth signs
+	{0x1F300, 0x1F64F}, // symbols, pictographs, emoticons
+	{0x1F680, 0x1F6FF}, // transport and map symbols
+	{0x1F900, 0x1F9FF}, // supplemental symbols and pictographs
+	{0x20000, 0x3FFFD}, // CJK extensions B and beyond
+}
+
+// isWide reports whether the character takes two columns in the terminal.
+func isWide(r rune) bool {
+	if r < wideRanges[0].lo {
+		return false
+	}
+	for _, rng := range wideRanges {
+		if r >= rng.lo && r <= rng.hi {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_terminalWidth(t *testing.T) {
+	if getTermWidth(os.Stdout) > 0 {
+		t.Skip("Skipping on a real terminal")
+	}
+
+	t.Run("columns", func(t *testing.T) {
+		t.Setenv("COLUMNS", "120")
+		be.Equal(t, terminalWidth(), 120)
+	})
+
+	t.Run("invalid columns", func(t *testing.T) {
+		t.Setenv("COLUMNS", "wide")
+		be.Equal(t, terminalWidth(), defaultWidth)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("COLUMNS", "")
+		be.Equal(t, terminalWidth(), defaultWidth)
+	})
+}
+
+func Test_wrap(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		width int
+		want  []string
+	}{
+		{
+			name:  "empty",
+			line:  "",
+			width: 10,
+			want:  []string{""},
+		},
+		{
+			name:  "multiple spaces",
+			line:  "hello    world",
+			width: 20,
+			want:  []string{"hello world"},
+		},
+		{
+			name:  "cyrillic",
+			line:  "команда выводит только заголовки",
+			width: 22,
+			want:  []string{"команда выводит только", "заголовки"},
+		},
+		{
+			name:  "cjk",
+			line:  "显示 文件 大小",
+			width: 10,
+			want:  []string{"显示 文件", "大小"},
+		},
+		{
+			name:  "bullet",
+			line:  "- The -I option fetches the headers only",
+			width: 20,
+			want:  []string{"- The -I option", "  fetches the", "  headers only"},
+		},
+		{
+			name:  "indented number",
+			line:  "  1. Fetch the headers only",
+			width: 16,
+			want:  []string{"  1. Fetch the", "     headers", "     only"},
+		},
+		{
+			name:  "inline command",
+			line:  "Run `curl -I example.org` to fetch",
+			width: 16,
+			want:  []string{"Run", "`curl -I example.org`", "to fetch"},
+		},
+		{
+			name:  "url",
+			line:  "See https://github.com/nalgeon/howto for details",
+			width: 20,
+			want:  []string{"See", "https://github.com/nalgeon/howto", "for details"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrap(tt.line, tt.width)
+			be.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func Test_truncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width int
+		want  string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact", "hello", 5, "hello"},
+		{"ascii", "hello world", 8, "hello..."},
+		{"cyrillic", "привет мир", 8, "приве..."},
+		{"wide", "显示文件大小", 8, "显示..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.s, tt.width)
+			be.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func Test_displayWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"hello", 5},
+		{"привет", 6},
+		{"显示", 4},
+		{"🧑 hi", 5},
+		{"é", 1},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			be.Equal(t, displayWidth(tt.s), tt.want)
+		})
+	}
+}
@@ -29,7 +29,7 @@ func main() {
 	}
 
 	ver := internal.NewVersion(version, commit, date)
-	err = internal.Howto(os.Stdout, ai.Ask, ver, os.Args[1:], history)
+	err = internal.Howto(os.Stdin, os.Stdout, ai.Ask, ai.Embed, ver, os.Args[1:], history)
 
 	if err != nil {
 		fmt.Println("ERROR:", err)